@@ -0,0 +1,392 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxLetters is the largest number of letters an alphabet may have, so that
+// a set of letters fits in a uint64.
+const maxLetters = 64
+
+// An Alphabet is the set of letters a Constructor learns and constructs
+// words from. Letters are numbered in alphabetical order; a letter may also
+// stand for other characters folded into it, like 'ё' into 'е' in Russian.
+type Alphabet struct {
+	letters    []rune
+	vowels     uint64
+	consonants uint64
+	folds      map[rune]rune
+	freq       []float64 // relative frequencies of the letters in the language
+	marks      string    // characters joining letters inside a word
+
+	// slots[i] is the letter held in slot i of the tables of .RWC files
+	// and of files without an alphabet, or unmapped.
+	slots []rune
+
+	index map[rune]byte // letters and folded characters of either case
+	all   uint64
+}
+
+var (
+	// Russian is the alphabet of 32 letters from 'а' to 'я' with 'ё'
+	// folded into 'е'. It is the alphabet of the zero Constructor, of
+	// DefaultConstructor and of the .RWC files.
+	Russian = newAlphabet(russianLetters, "аеиоуыэюя",
+		"бвгджзйклмнпрстфхцчшщ", map[rune]rune{'ё': 'е'}, letterFreq)
+
+	// RussianYo is the Russian alphabet of 33 letters, with 'ё' as a
+	// letter on its own.
+	RussianYo = newAlphabet("абвгдеёжзийклмнопрстуфхцчшщъыьэюя", "аеёиоуыэюя",
+		"бвгджзйклмнпрстфхцчшщ", nil, yoLetterFreq)
+
+	// Ukrainian is the Ukrainian alphabet of 33 letters. An apostrophe
+	// between two letters, as in "м'ясо", belongs to the word but is not
+	// a letter: the word is learned as "мясо".
+	Ukrainian = newAlphabet("абвгґдеєжзиіїйклмнопрстуфхцчшщьюя", "аеєиіїоуюя",
+		"бвгґджзйклмнпрстфхцчшщ", nil, nil).withMarks(apostrophes)
+
+	// Belarusian is the Belarusian alphabet of 32 letters. An apostrophe
+	// between two letters belongs to the word as in Ukrainian.
+	Belarusian = newAlphabet("абвгдеёжзійклмнопрстуўфхцчшыьэюя", "аеёіоуыэюя",
+		"бвгджзйклмнпрстўфхцчш", nil, nil).withMarks(apostrophes)
+
+	// Latin is the basic Latin alphabet of 26 letters used by English.
+	// Y is both a vowel and a consonant. The English .RWC files, such as
+	// vocab/ENGLISH.RWC, hold English words spelled in Cyrillic, as in
+	// "лукинг"; a constructor with this alphabet maps those letters to
+	// Latin ones when it loads them, so "лукинг" becomes "luking". See
+	// MapSlots for files holding Latin letters.
+	Latin = newAlphabet("abcdefghijklmnopqrstuvwxyz", "aeiouy",
+		"bcdfghjklmnpqrstvwxyz", nil, latinLetterFreq).mapSlots("abvgdejziyklmnoprstufhccss-y-eua")
+)
+
+const russianLetters = "абвгдежзийклмнопрстуфхцчшщъыьэюя"
+
+// apostrophes are the characters used as the apostrophe in Ukrainian and
+// Belarusian: the typewriter one, the modifier letter and the right single
+// quotation mark.
+const apostrophes = "'\u02bc\u2019"
+
+// unmapped marks a slot whose letters are dropped, like 'ь' in the
+// English .RWC files.
+const unmapped = '-'
+
+// alphabets are the predefined alphabets. An alphabet loaded from a file
+// is replaced with the predefined one having the same letters.
+var alphabets = []*Alphabet{Russian, RussianYo, Ukrainian, Belarusian, Latin}
+
+// NewAlphabet returns an alphabet of the letters, which must be distinct
+// lowercase letters listed in alphabetical order, at most 64 of them.
+// Vowels and consonants are the letters matched by V and C in masks; if
+// consonants is empty, every letter that is not a vowel is a consonant.
+func NewAlphabet(letters, vowels, consonants string) (*Alphabet, error) {
+	rr := []rune(letters)
+	switch {
+	case len(rr) == 0:
+		return nil, errors.New("empty alphabet")
+	case len(rr) > maxLetters:
+		return nil, fmt.Errorf("%d letters in an alphabet, at most %d allowed", len(rr), maxLetters)
+	}
+	seen := make(map[rune]bool)
+	for _, r := range rr {
+		if !unicode.IsLetter(r) || unicode.ToLower(r) != r || seen[r] || seen[unicode.ToUpper(r)] {
+			return nil, fmt.Errorf("invalid letter %q in an alphabet", r)
+		}
+		seen[r] = true
+		seen[unicode.ToUpper(r)] = true
+	}
+	for _, r := range vowels + consonants {
+		if !strings.ContainsRune(letters, r) {
+			return nil, fmt.Errorf("%q is not a letter of the alphabet", r)
+		}
+	}
+	if consonants == "" {
+		consonants = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(vowels, r) {
+				return -1
+			}
+			return r
+		}, letters)
+	}
+	return newAlphabet(letters, vowels, consonants, nil, nil), nil
+}
+
+func newAlphabet(letters, vowels, consonants string, folds map[rune]rune, freq []float64) *Alphabet {
+	a := &Alphabet{
+		letters: []rune(letters),
+		folds:   folds,
+		freq:    freq,
+		index:   make(map[rune]byte),
+	}
+	for i, r := range a.letters {
+		a.index[r] = byte(i)
+		a.index[unicode.ToUpper(r)] = byte(i)
+		a.all |= 1 << uint(i)
+	}
+	for from, to := range folds {
+		a.index[from] = a.index[to]
+		a.index[unicode.ToUpper(from)] = a.index[to]
+	}
+	for _, r := range vowels {
+		a.vowels |= 1 << a.index[r]
+	}
+	for _, r := range consonants {
+		a.consonants |= 1 << a.index[r]
+	}
+	return a.mapSlots(russianLetters)
+}
+
+func (a *Alphabet) withMarks(marks string) *Alphabet {
+	a.marks = marks
+	return a
+}
+
+func (a *Alphabet) mapSlots(slots string) *Alphabet {
+	a.slots = []rune(slots)
+	return a
+}
+
+// MapSlots returns a copy of a that reads the tables of .RWC files and of
+// files without an alphabet, which have 32 slots for letters, holding
+// letter i of slots in slot i. A '-' in slots drops the n-grams having the
+// letter of that slot. By default, these tables hold the Russian letters
+// from 'а' to 'я'. For example, a constructor with alphabet
+// Latin.MapSlots("abcdefghijklmnopqrstuvwxyz") loads an .RWC file holding
+// the Latin letters in their alphabetical order.
+func (a *Alphabet) MapSlots(slots string) (*Alphabet, error) {
+	rr := []rune(slots)
+	if len(rr) > 32 {
+		return nil, fmt.Errorf("%d slots, at most 32 allowed", len(rr))
+	}
+	for _, r := range rr {
+		if _, ok := a.index[r]; !ok && r != unmapped {
+			return nil, fmt.Errorf("%q is not a letter of the alphabet", r)
+		}
+	}
+	b := *a
+	return b.mapSlots(slots), nil
+}
+
+// slotIndexes returns the index of the letter held in every slot of the
+// tables, or 0xff.
+func (a *Alphabet) slotIndexes() ([]byte, error) {
+	slots := make([]byte, 32)
+	for i := range slots {
+		slots[i] = 0xff
+		if i >= len(a.slots) || a.slots[i] == unmapped {
+			continue
+		}
+		b, ok := a.index[a.slots[i]]
+		if !ok {
+			return nil, fmt.Errorf("the alphabet has no letter %q to load the tables", a.slots[i])
+		}
+		slots[i] = b
+	}
+	return slots, nil
+}
+
+// Letters returns the letters of the alphabet in alphabetical order.
+func (a *Alphabet) Letters() string {
+	return string(a.letters)
+}
+
+// Vowels returns the letters matched by V in masks.
+func (a *Alphabet) Vowels() string {
+	return a.subset(a.vowels)
+}
+
+// Consonants returns the letters matched by C in masks.
+func (a *Alphabet) Consonants() string {
+	return a.subset(a.consonants)
+}
+
+func (a *Alphabet) subset(set uint64) string {
+	var rr []rune
+	for i, r := range a.letters {
+		if set&(1<<uint(i)) != 0 {
+			rr = append(rr, r)
+		}
+	}
+	return string(rr)
+}
+
+// classic reports whether words in a fit the tables of Constructor, which
+// have room for 32 letters.
+func (a *Alphabet) classic() bool {
+	return len(a.letters) <= 32
+}
+
+// letterIndex returns the index of a letter of either case.
+func (a *Alphabet) letterIndex(r rune) (byte, bool) {
+	b, ok := a.index[r]
+	return b, ok
+}
+
+// indexes converts a word into letter indexes.
+func (a *Alphabet) indexes(word string) ([]byte, bool) {
+	w := make([]byte, 0, utf8.RuneCountInString(word))
+	for _, r := range word {
+		b, ok := a.index[r]
+		if !ok {
+			return nil, false
+		}
+		w = append(w, b)
+	}
+	return w, true
+}
+
+func (a *Alphabet) makeString(w []byte) string {
+	rr := make([]rune, len(w))
+	for i, b := range w {
+		rr[i] = a.letters[b]
+	}
+	return string(rr)
+}
+
+// appendLetters appends the letters of w to dst encoded in UTF-8.
+func (a *Alphabet) appendLetters(dst, w []byte) []byte {
+	var buf [utf8.UTFMax]byte
+	for _, b := range w {
+		n := utf8.EncodeRune(buf[:], a.letters[b])
+		dst = append(dst, buf[:n]...)
+	}
+	return dst
+}
+
+// maskSet returns the letters matched by a letter index or by one of
+// maskAny, maskVowel and maskConsonant.
+func (a *Alphabet) maskSet(how byte) uint64 {
+	switch how {
+	case maskAny:
+		return a.all
+	case maskVowel:
+		return a.vowels
+	case maskConsonant:
+		return a.consonants
+	default:
+		return 1 << how
+	}
+}
+
+// weight returns the relative frequency of letter b in the language.
+func (a *Alphabet) weight(b byte) float64 {
+	if a.freq == nil {
+		return 1
+	}
+	return a.freq[b]
+}
+
+func (c *Constructor) alphabet() *Alphabet {
+	if c.abc == nil {
+		return Russian
+	}
+	return c.abc
+}
+
+func (c *Constructor) makeString(w []byte) string {
+	return c.alphabet().makeString(w)
+}
+
+// marshal encodes the alphabet as its letters, vowels, consonants and the
+// pairs of a folded character and its letter, separated by zero bytes.
+func (a *Alphabet) marshal() []byte {
+	var folds []string
+	for from, to := range a.folds {
+		folds = append(folds, string([]rune{from, to}))
+	}
+	sort.Strings(folds)
+	return []byte(strings.Join([]string{string(a.letters), a.Vowels(), a.Consonants(), strings.Join(folds, "")}, "\x00"))
+}
+
+func unmarshalAlphabet(data []byte) (*Alphabet, error) {
+	for _, a := range alphabets {
+		if string(a.marshal()) == string(data) {
+			return a, nil
+		}
+	}
+
+	parts := strings.Split(string(data), "\x00")
+	if len(parts) != 4 {
+		return nil, errors.New("invalid alphabet section")
+	}
+	a, err := NewAlphabet(parts[0], parts[1], parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if parts[3] != "" {
+		pairs := []rune(parts[3])
+		if len(pairs)%2 != 0 {
+			return nil, errors.New("invalid alphabet section")
+		}
+		folds := make(map[rune]rune)
+		for i := 0; i < len(pairs); i += 2 {
+			if _, ok := a.index[pairs[i+1]]; !ok {
+				return nil, errors.New("invalid alphabet section")
+			}
+			folds[pairs[i]] = pairs[i+1]
+		}
+		a = newAlphabet(parts[0], parts[1], parts[2], folds, nil)
+	}
+	return a, nil
+}
+
+// words returns the byte offsets of the beginning and the end of every
+// word in s, a word being a run of letters of the alphabet, possibly joined
+// by its marks.
+func (a *Alphabet) words(s string) [][2]int {
+	var ww [][2]int
+	beg := -1
+	for i, r := range s {
+		_, ok := a.index[r]
+		if !ok && beg >= 0 && a.isMark(r) {
+			next, _ := utf8.DecodeRuneInString(s[i+utf8.RuneLen(r):])
+			_, ok = a.index[next]
+		}
+		switch {
+		case ok && beg < 0:
+			beg = i
+		case !ok && beg >= 0:
+			ww = append(ww, [2]int{beg, i})
+			beg = -1
+		}
+	}
+	if beg >= 0 {
+		ww = append(ww, [2]int{beg, len(s)})
+	}
+	return ww
+}
+
+func (a *Alphabet) isMark(r rune) bool {
+	return a.marks != "" && strings.ContainsRune(a.marks, r)
+}
+
+// stripMarks returns a word found by words without its marks.
+func (a *Alphabet) stripMarks(word string) string {
+	if a.marks == "" {
+		return word
+	}
+	return strings.Map(func(r rune) rune {
+		if a.isMark(r) {
+			return -1
+		}
+		return r
+	}, word)
+}
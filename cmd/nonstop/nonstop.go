@@ -29,6 +29,7 @@ import (
 var (
 	numLines       = flag.Int("l", 0, "Number of lines to print (default 0 = infinity)")
 	vocabularyFile = flag.String("v", "", "Vocabulary file")
+	textFile       = flag.String("t", "", "Text file to learn words and their lengths from, in addition to the vocabulary")
 )
 
 const (
@@ -177,6 +178,15 @@ func LoadVocabulary(filename string) error {
 	return nil
 }
 
+func LearnText(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rwc.DefaultConstructor.LearnFrom(f)
+}
+
 func main() {
 	flag.Parse()
 	if *numLines == 0 {
@@ -187,6 +197,11 @@ func main() {
 			log.Fatal(err)
 		}
 	}
+	if *textFile != "" {
+		if err := LearnText(*textFile); err != nil {
+			log.Fatal(err)
+		}
+	}
 	rand.Seed(time.Now().UnixNano())
 
 	flags, old_flags := BOS, BOS
@@ -281,12 +296,11 @@ func main() {
 			flags &= ^(NOONE | WASPUN | WASONE)
 			flags |= (PUN | EOS)
 		} else {
-			if possible(18) {
-				l = 6 + rand.Intn(8)
-			} else {
-				l = 4 + rand.Intn(4)
+			l = copy(w, []rune(rwc.WordBetween(4, 13)))
+			if l == 0 {
+				flags, lastopened = old_flags, old_lastopened
+				continue
 			}
-			copy(w, []rune(rwc.Word(l)))
 			flags &= ^(NOONE | WASONE | WASPUN)
 			flags |= (PUN | EOS)
 		}
@@ -0,0 +1,87 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Command pseudonymize replaces the Russian words of texts with
+// pseudo-words of the same shape. It reads the files named on the command
+// line, or the standard input, and writes to the standard output.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/opennota/rwc"
+)
+
+var (
+	vocabularyFile = flag.String("v", "", "Vocabulary file")
+	textFile       = flag.String("t", "", "Text file to learn words from, in addition to the vocabulary")
+)
+
+func LoadVocabulary(filename string) error {
+	if err := rwc.DefaultConstructor.LoadFromRWC(filename); err != nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := rwc.DefaultConstructor.LoadFrom(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func LearnText(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rwc.DefaultConstructor.LearnFrom(f)
+}
+
+func Pseudonymize(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rwc.Pseudonymize(os.Stdout, f)
+}
+
+func main() {
+	flag.Parse()
+	if *vocabularyFile != "" {
+		if err := LoadVocabulary(*vocabularyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *textFile != "" {
+		if err := LearnText(*textFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if flag.NArg() == 0 {
+		if err := rwc.Pseudonymize(os.Stdout, os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, filename := range flag.Args() {
+		if err := Pseudonymize(filename); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/big"
+	"math/bits"
+)
+
+// ErrMismatch is returned by Codec.Decode for words that were not encoded by
+// a codec with the same mask and the same tables, or were mistyped.
+var ErrMismatch = errors.New("words do not match the vocabulary")
+
+// A Codec encodes bytes into words matching a mask and decodes them back.
+// Every word stands for the same number of bytes, the width of the codec,
+// except that the last word of the data may stand for fewer of them. The
+// data is followed by a 32-bit checksum of the bytes, the mask and the
+// vocabulary, that is everything WriteTo writes, which takes as many words
+// as it needs: four for a codec of width 1, two for width 2 and one for a
+// greater width. So a corrupted input decodes by chance only once in 2^32
+// times. The words decode with any codec made from the same vocabulary and
+// mask, so they are stable as long as the vocabulary file is.
+//
+// A Codec is safe for concurrent use.
+type Codec struct {
+	c     *Constructor
+	pl    *plan
+	width int
+	sums  int        // the number of words of the checksum
+	full  *big.Int   // the number of values of width bytes
+	tails []*big.Int // tails[r] is the first index standing for r bytes
+	sum   uint32     // checksum of the tables and the mask
+}
+
+// NewCodec returns a codec of DefaultConstructor.
+func NewCodec(mask string) (*Codec, error) {
+	return DefaultConstructor.NewCodec(mask)
+}
+
+// NewCodec returns a codec encoding bytes into words matching the mask
+// that c is able to construct. An empty mask stands for "CVCVCV". The codec
+// keeps a copy of c, so c may change afterwards. NewCodec returns a
+// *MaskError for an invalid mask and an error if fewer than 256 words
+// match the mask.
+func (c *Constructor) NewCodec(mask string) (*Codec, error) {
+	if mask == "" {
+		mask = "CVCVCV"
+	}
+	m, err := CompileMask(mask)
+	if err != nil {
+		return nil, err
+	}
+	c = c.clone()
+	pl := m.plan(c)
+	if err := pl.check(); err != nil && err != ErrNoMatch {
+		return nil, err
+	}
+
+	// Every index below full stands for width bytes, and the following
+	// ones for fewer of them: all the values of one byte, then of two
+	// bytes and so on.
+	k := &Codec{c: c, pl: pl}
+	for {
+		full := new(big.Int).Lsh(one, uint(8*(k.width+1)))
+		tails := []*big.Int{nil, full}
+		end := new(big.Int).Set(full)
+		for r := 1; r <= k.width; r++ {
+			end.Add(end, new(big.Int).Lsh(one, uint(8*r)))
+			tails = append(tails, new(big.Int).Set(end))
+		}
+		if end.Cmp(pl.total) > 0 {
+			break
+		}
+		k.width++
+		k.full, k.tails = full, tails
+	}
+	if k.width == 0 {
+		return nil, fmt.Errorf("%v words match %q, at least 256 needed", pl.total, mask)
+	}
+	k.sums = (crc32.Size + k.width - 1) / k.width
+
+	h := crc32.NewIEEE()
+	if _, err := c.WriteTo(h); err != nil {
+		return nil, err
+	}
+	h.Write([]byte(mask))
+	k.sum = h.Sum32()
+	return k, nil
+}
+
+// Width returns the number of bytes every word stands for.
+func (k *Codec) Width() int {
+	return k.width
+}
+
+// Encode returns the words standing for data, followed by the checksum.
+func (k *Codec) Encode(data []byte) []string {
+	var ww []string
+	all := data
+	index := new(big.Int)
+	for len(data) > 0 {
+		r := k.width
+		if len(data) < r {
+			r = len(data)
+		}
+		index.SetBytes(data[:r])
+		if r < k.width {
+			index.Add(index, k.tails[r])
+		}
+		ww = append(ww, k.word(index))
+		data = data[r:]
+	}
+	for _, index := range k.checksum(all) {
+		ww = append(ww, k.word(index))
+	}
+	return ww
+}
+
+func (k *Codec) word(index *big.Int) string {
+	return k.c.makeString(k.pl.unrank(index))
+}
+
+// checksum returns the indexes of the checksum words of data.
+func (k *Codec) checksum(data []byte) []*big.Int {
+	b := make([]byte, k.sums*k.width)
+	binary.BigEndian.PutUint32(b[len(b)-crc32.Size:], crc32.Update(k.sum, crc32.IEEETable, data))
+	indexes := make([]*big.Int, k.sums)
+	for i := range indexes {
+		indexes[i] = new(big.Int).SetBytes(b[i*k.width : (i+1)*k.width])
+	}
+	return indexes
+}
+
+// Decode returns the bytes standing for the words, which may be of either
+// case. It returns an error wrapping ErrMismatch if a word is not one of
+// the words of the codec or the checksum does not match, which is what
+// happens if the words were encoded by a codec with other tables.
+func (k *Codec) Decode(words []string) ([]byte, error) {
+	if len(words) < k.sums {
+		return nil, fmt.Errorf("%w: no checksum", ErrMismatch)
+	}
+	var data []byte
+	last := len(words) - k.sums // the first word of the checksum
+	indexes := make([]*big.Int, len(words))
+	for i, word := range words {
+		index, ok := k.rank(word)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrMismatch, word)
+		}
+		indexes[i] = index
+		if i >= last {
+			continue
+		}
+		r := k.width
+		if index.Cmp(k.full) >= 0 {
+			for r = 1; r < k.width && index.Cmp(k.tails[r+1]) >= 0; r++ {
+			}
+			if i != last-1 {
+				return nil, fmt.Errorf("%w: short word %q not at the end", ErrMismatch, word)
+			}
+			index.Sub(index, k.tails[r])
+		}
+		data = append(data, index.FillBytes(make([]byte, r))...)
+	}
+	for i, index := range k.checksum(data) {
+		if index.Cmp(indexes[last+i]) != 0 {
+			return nil, fmt.Errorf("%w: wrong checksum", ErrMismatch)
+		}
+	}
+	return data, nil
+}
+
+// rank returns the index of a word, or false if it is not a word of k.
+func (k *Codec) rank(word string) (*big.Int, bool) {
+	w, ok := k.c.alphabet().indexes(word)
+	if !ok {
+		return nil, false
+	}
+	index, ok := k.pl.rank(w)
+	if !ok || index.Cmp(k.tails[len(k.tails)-1]) >= 0 {
+		return nil, false
+	}
+	return index, true
+}
+
+// rank returns the index of w in the list of the words accepted by the
+// lattices of pl in turn, or false if they do not accept w.
+func (pl *plan) rank(w []byte) (*big.Int, bool) {
+	offset := new(big.Int)
+	for _, l := range pl.lattices {
+		if l.n == len(w) {
+			r, ok := l.rank(w)
+			if !ok {
+				return nil, false
+			}
+			return r.Add(r, offset), true
+		}
+		offset.Add(offset, l.total())
+	}
+	return nil, false
+}
+
+// rank returns the index of w in the lexicographically ordered list of the
+// words accepted by l, or false if l does not accept w. It is the inverse
+// of unrank.
+func (l *lattice) rank(w []byte) (*big.Int, bool) {
+	r := new(big.Int)
+	var st state
+	for i := 0; i < l.n; i++ {
+		s := l.letters(w, i, st)
+		if s&(1<<w[i]) == 0 {
+			return nil, false
+		}
+		for s &= 1<<w[i] - 1; s != 0; s &= s - 1 {
+			b := bits.TrailingZeros64(s)
+			if cnt := l.count(i+1, l.step(i, st, byte(b))); cnt != nil {
+				r.Add(r, cnt)
+			}
+		}
+		st = l.step(i, st, w[i])
+		if l.count(i+1, st) == nil {
+			return nil, false
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,325 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// CountLength returns the number of distinct pseudo-Russian words of the
+// specified length.
+func CountLength(length int) *big.Int {
+	return DefaultConstructor.CountLength(length)
+}
+
+// CountMask returns the number of distinct pseudo-Russian words matching
+// the mask.
+func CountMask(mask string) (*big.Int, error) {
+	return DefaultConstructor.CountMask(mask)
+}
+
+// CountLength returns the number of distinct words of length n that c is
+// able to construct.
+func (c *Constructor) CountLength(n int) *big.Int {
+	if n <= 0 {
+		return new(big.Int)
+	}
+	return c.buildLattices(lengthPattern(c.alphabet(), n), []int{n})[0].total()
+}
+
+// CountMask returns the number of distinct words matching the mask that c
+// is able to construct. The mask syntax is the same as for WordMask.
+// Words of every length the mask allows are counted.
+func (c *Constructor) CountMask(mask string) (*big.Int, error) {
+	if mask == "" {
+		return new(big.Int), nil
+	}
+	p, err := compileMask(mask, c.alphabet())
+	if err != nil {
+		return nil, err
+	}
+	total := new(big.Int)
+	for _, l := range c.buildLattices(p, p.lengths()) {
+		total.Add(total, l.total())
+	}
+	return total, nil
+}
+
+// A lattice counts the ways to complete a word of length n matching a
+// pattern. Since the tables never look more than k letters back, three
+// unless the constructor is of a higher order, the count depends only on
+// the position, the last k letters and the state of the pattern, which
+// keeps the number of states small. Past the first k letters, it depends on
+// the number of letters left rather than on the position, so the lattices
+// of all the lengths of a pattern share their tail.
+type lattice struct {
+	c *Constructor
+	p *pattern
+	n int
+	k int
+	h int // the number of letters counted by head, min(k, n)
+	// head[i][st] is the number of accepted completions of a prefix of
+	// length i < h that ends in st. Dead ends are omitted.
+	head []map[state]*big.Int
+	tail *tail
+}
+
+// A tail counts the accepted completions of the prefixes of k letters or
+// more. In its states, the state of the pattern is replaced with its class
+// for the number of letters left.
+type tail struct {
+	classes []class
+	ids     map[[maxLetters]int32]int32 // classes by their next
+	cls     map[[2]int32]int32          // the class of a state and a number of letters left
+	counts  map[state]*big.Int          // dead ends are omitted
+}
+
+// A class stands for the states of a pattern from which the same words of
+// the same length lead to the end. Class 0 is the end itself.
+type class struct {
+	letters uint64
+	next    [maxLetters]int32 // the class after a letter, or -1
+}
+
+// A state is the last (up to k) letters of a prefix packed into ctx and
+// the state of the pattern after it or, past the first k letters, its
+// class.
+type state struct {
+	ctx uint64
+	d   int32
+}
+
+var one = big.NewInt(1)
+
+// unpack stores the letters packed in ctx right before position i of w.
+func (l *lattice) unpack(w []byte, i int, ctx uint64) {
+	for j := i - 1; j >= 0 && j >= i-l.k; j-- {
+		w[j] = byte(ctx & 63)
+		ctx >>= 6
+	}
+}
+
+// shift appends letter b to the letters packed in ctx, as pack does,
+// keeping the last k.
+func (l *lattice) shift(ctx uint64, b byte) uint64 {
+	return (ctx<<6 | uint64(b)) & (1<<uint(6*l.k) - 1)
+}
+
+// allowed returns the letters that may follow w[:i] in a word of length
+// len(w) ending in a state of the pattern reachable from d.
+func (c *Constructor) allowed(p *pattern, d int32, w []byte, i int) uint64 {
+	return c.next(w, i) & p.allowed(d, len(w)-i-1)
+}
+
+// letters returns the letters that may follow w[:i] ending in st. Not all
+// of them lead to a complete word; see count.
+func (l *lattice) letters(w []byte, i int, st state) uint64 {
+	if i < l.h {
+		return l.c.allowed(l.p, st.d, w, i)
+	}
+	return l.c.next(w, i) & l.tail.classes[st.d].letters
+}
+
+// step returns the state after letter b following a prefix of length i
+// ending in st.
+func (l *lattice) step(i int, st state, b byte) state {
+	ctx := l.shift(st.ctx, b)
+	switch {
+	case i+1 == l.n:
+		return state{ctx, 0}
+	case i+1 < l.h:
+		return state{ctx, l.p.delta[st.d][b]}
+	case i+1 == l.h:
+		cl, ok := l.tail.cls[[2]int32{l.p.delta[st.d][b], int32(l.n - l.h)}]
+		if !ok {
+			cl = -1
+		}
+		return state{ctx, cl}
+	}
+	return state{ctx, l.tail.classes[st.d].next[b]}
+}
+
+// count returns the number of accepted completions of a prefix of length i
+// ending in st, or nil if there is none.
+func (l *lattice) count(i int, st state) *big.Int {
+	switch {
+	case i == l.n:
+		return one
+	case i < l.h:
+		return l.head[i][st]
+	}
+	return l.tail.counts[st]
+}
+
+// class returns the class of state d with r letters left, or -1 if no word
+// of r letters leads from d to the end.
+func (t *tail) class(p *pattern, d int32, r int) int32 {
+	if !p.ends(d, r) {
+		return -1
+	}
+	if r == 0 {
+		return 0
+	}
+	key := [2]int32{d, int32(r)}
+	if id, ok := t.cls[key]; ok {
+		return id
+	}
+	var cl class
+	for b := range cl.next {
+		cl.next[b] = -1
+	}
+	for s := p.letters[d]; s != 0; s &= s - 1 {
+		b := bits.TrailingZeros64(s)
+		if next := t.class(p, p.delta[d][b], r-1); next >= 0 {
+			cl.next[b] = next
+			cl.letters |= 1 << uint(b)
+		}
+	}
+	id, ok := t.ids[cl.next]
+	if !ok {
+		id = int32(len(t.classes))
+		t.classes = append(t.classes, cl)
+		t.ids[cl.next] = id
+	}
+	t.cls[key] = id
+	return id
+}
+
+// buildLattices returns the lattices of p for the lengths nn, sharing
+// their tail.
+func (c *Constructor) buildLattices(p *pattern, nn []int) []*lattice {
+	k := c.order() - 1
+	t := &tail{
+		classes: []class{{}},
+		ids:     make(map[[maxLetters]int32]int32),
+		cls:     make(map[[2]int32]int32),
+		counts:  make(map[state]*big.Int),
+	}
+	ll := make([]*lattice, len(nn))
+	reach := make([][]map[state]bool, len(nn))
+	var tailReach []map[state]bool // by the number of letters left
+	for j, n := range nn {
+		l := &lattice{c: c, p: p, n: n, k: k, h: k, tail: t}
+		if n < k {
+			l.h = n
+		}
+		ll[j] = l
+
+		w := make([]byte, n)
+		reach[j] = make([]map[state]bool, l.h)
+		reach[j][0] = map[state]bool{{}: true}
+		for i := 0; i < l.h && i < n-1; i++ {
+			next := make(map[state]bool)
+			for st := range reach[j][i] {
+				l.unpack(w, i, st.ctx)
+				for s := c.allowed(p, st.d, w, i); s != 0; s &= s - 1 {
+					b := bits.TrailingZeros64(s)
+					d := p.delta[st.d][b]
+					if i+1 < l.h {
+						next[state{l.shift(st.ctx, byte(b)), d}] = true
+					} else if cl := t.class(p, d, n-l.h); cl >= 0 {
+						next[state{l.shift(st.ctx, byte(b)), cl}] = true
+					}
+				}
+			}
+			if i+1 < l.h {
+				reach[j][i+1] = next
+				continue
+			}
+			r := n - l.h
+			for len(tailReach) <= r {
+				tailReach = append(tailReach, nil)
+			}
+			if tailReach[r] == nil {
+				tailReach[r] = make(map[state]bool)
+			}
+			for st := range next {
+				tailReach[r][st] = true
+			}
+		}
+	}
+
+	// The tail: a letter at least k letters into a word with r letters
+	// left is allowed by the tables and the class, whatever the length.
+	if len(tailReach) > 0 {
+		l := &lattice{k: k}
+		wbuf := make([]byte, k+len(tailReach))
+		for r := len(tailReach) - 1; r > 1; r-- {
+			w := wbuf[:k+r]
+			for st := range tailReach[r] {
+				l.unpack(w, k, st.ctx)
+				cl := &t.classes[st.d]
+				for s := c.next(w, k) & cl.letters; s != 0; s &= s - 1 {
+					b := bits.TrailingZeros64(s)
+					if tailReach[r-1] == nil {
+						tailReach[r-1] = make(map[state]bool)
+					}
+					tailReach[r-1][state{l.shift(st.ctx, byte(b)), cl.next[b]}] = true
+				}
+			}
+		}
+		for r := 1; r < len(tailReach); r++ {
+			w := wbuf[:k+r]
+			for st := range tailReach[r] {
+				l.unpack(w, k, st.ctx)
+				cl := &t.classes[st.d]
+				sum := new(big.Int)
+				for s := c.next(w, k) & cl.letters; s != 0; s &= s - 1 {
+					if r == 1 {
+						sum.Add(sum, one)
+						continue
+					}
+					b := bits.TrailingZeros64(s)
+					if cnt := t.counts[state{l.shift(st.ctx, byte(b)), cl.next[b]}]; cnt != nil {
+						sum.Add(sum, cnt)
+					}
+				}
+				if sum.Sign() > 0 {
+					t.counts[st] = sum
+				}
+			}
+		}
+	}
+
+	for j, l := range ll {
+		w := make([]byte, l.n)
+		l.head = make([]map[state]*big.Int, l.h)
+		for i := l.h - 1; i >= 0; i-- {
+			l.head[i] = make(map[state]*big.Int)
+			for st := range reach[j][i] {
+				l.unpack(w, i, st.ctx)
+				sum := new(big.Int)
+				for s := c.allowed(p, st.d, w, i); s != 0; s &= s - 1 {
+					b := bits.TrailingZeros64(s)
+					if cnt := l.count(i+1, l.step(i, st, byte(b))); cnt != nil {
+						sum.Add(sum, cnt)
+					}
+				}
+				if sum.Sign() > 0 {
+					l.head[i][st] = sum
+				}
+			}
+		}
+	}
+	return ll
+}
+
+// total returns the number of accepted words.
+func (l *lattice) total() *big.Int {
+	if cnt := l.count(0, state{}); cnt != nil {
+		return new(big.Int).Set(cnt)
+	}
+	return new(big.Int)
+}
@@ -0,0 +1,127 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import "fmt"
+
+// A Rejection describes why a constructor does not accept a word.
+type Rejection struct {
+	// Offset is the index of the first letter that is not allowed,
+	// counted in letters rather than bytes.
+	Offset int
+	// Table is the n-gram table that does not allow the letter: "ng1" or
+	// "ng2" for the words of one or two letters, "ng3" for the words of
+	// three letters, "ng3beg" for the third letter of longer words,
+	// "ng3end" for their last letter and "ng4" for any other letter, or
+	// "ng5" and so on for the longest context the constructor has seen if
+	// it is of a higher order (see Options.Order), or "end1" for the
+	// last letter, "end2" for the one before it and so on if the
+	// constructor has tables of the positions at the end of words (see
+	// Options.SuffixLen). It is empty if the word is empty or has a
+	// character that is not a letter of the alphabet of the constructor.
+	Table string
+	// NGram is the offending letter sequence, ending with the letter that
+	// is not allowed, or the offending character.
+	NGram string
+}
+
+func (r *Rejection) Error() string {
+	if r.Table == "" {
+		if r.NGram == "" {
+			return "empty word"
+		}
+		return fmt.Sprintf("%q at offset %d is not a letter of the alphabet", r.NGram, r.Offset)
+	}
+	return fmt.Sprintf("%s does not allow %q at offset %d", r.Table, r.NGram, r.Offset)
+}
+
+// Accepts reports whether the default constructor is able to construct
+// word. See Constructor.Accepts for details.
+func Accepts(word string) bool {
+	return DefaultConstructor.Accepts(word)
+}
+
+// Diagnose explains why the default constructor does not accept word.
+// See Constructor.Diagnose for details.
+func Diagnose(word string) error {
+	return DefaultConstructor.Diagnose(word)
+}
+
+// Accepts reports whether c is able to construct word, that is, whether
+// the word passes the same checks as the words c generates. The word may
+// contain uppercase letters; the Russian alphabet treats 'ё' as 'е'.
+func (c *Constructor) Accepts(word string) bool {
+	return c.Diagnose(word) == nil
+}
+
+// Diagnose returns nil if c accepts word (see Accepts), and otherwise a
+// *Rejection pointing at the first letter that is not allowed.
+func (c *Constructor) Diagnose(word string) error {
+	rr := []rune(word)
+	if len(rr) == 0 {
+		return &Rejection{}
+	}
+	a := c.alphabet()
+	w := make([]byte, len(rr))
+	for i, r := range rr {
+		b, ok := a.letterIndex(r)
+		if !ok {
+			return &Rejection{Offset: i, NGram: string(r)}
+		}
+		w[i] = b
+	}
+
+	n := len(w)
+	for i := range w {
+		if c.check(w, i) {
+			continue
+		}
+		ngram := w
+		table := c.rejectedBy(w, i)
+		switch {
+		case table == tabNg3beg:
+			ngram = w[:3]
+		case table == tabNg3end:
+			ngram = w[n-3:]
+		case table >= tabEnd:
+			ngram = w[i-3 : i+1]
+		case table >= tabNg4:
+			ngram = w[i-(table-tabNg4+3) : i+1]
+		}
+		return &Rejection{i, tableName(table), c.makeString(ngram)}
+	}
+	return nil
+}
+
+// rejectedBy returns the table that does not allow letter i of w, provided
+// next does not allow it.
+func (c *Constructor) rejectedBy(w []byte, i int) int {
+	if c.m != nil {
+		return c.m.rejectedBy(w, i)
+	}
+	n := len(w)
+	switch {
+	case n == 1:
+		return tabNg1
+	case n == 2:
+		return tabNg2
+	case n == 3:
+		return tabNg3
+	case i == 2:
+		return tabNg3beg
+	case i == n-1 && c.ng3end[uint16(w[n-3])<<5+uint16(w[n-2])]&(1<<w[n-1]) == 0:
+		return tabNg3end
+	}
+	return tabNg4
+}
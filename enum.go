@@ -0,0 +1,90 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import "math/bits"
+
+// EnumerateMask calls fn for every pseudo-Russian word matching the mask,
+// in lexicographic order, until fn returns false.
+// See Constructor.EnumerateMask for details.
+func EnumerateMask(mask string, offset, limit int, fn func(string) bool) error {
+	return DefaultConstructor.EnumerateMask(mask, offset, limit, fn)
+}
+
+// EnumerateMask calls fn for every word matching the mask that c is able
+// to construct, in lexicographic order, until fn returns false. If the mask
+// allows words of different lengths, shorter words come first.
+// The mask syntax is the same as for WordMask. The first offset words are
+// skipped. If limit is positive, at most limit words are passed to fn.
+// Known words are left out if c excludes them (see ExcludeKnown).
+func (c *Constructor) EnumerateMask(mask string, offset, limit int, fn func(string) bool) error {
+	if mask == "" {
+		return nil
+	}
+	p, err := compileMask(mask, c.alphabet())
+	if err != nil {
+		return err
+	}
+	for _, n := range p.lengths() {
+		more := c.enumerate(p, n, func(w []byte) bool {
+			if c.excludeKnown && c.known != nil && c.known.has(w) {
+				return true
+			}
+			if offset > 0 {
+				offset--
+				return true
+			}
+			if !fn(c.makeString(w)) {
+				return false
+			}
+			limit--
+			return limit != 0
+		})
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+// enumerate walks the words of length n matching p in lexicographic order,
+// calling fn for every word accepted by the tables until fn returns false.
+// fn must not retain w. It reports whether fn never returned false.
+func (c *Constructor) enumerate(p *pattern, n int, fn func(w []byte) bool) bool {
+	w := make([]byte, n)
+	d := make([]int32, n+1)
+	left := make([]uint64, n)
+	left[0] = c.allowed(p, 0, w, 0)
+	for i := 0; ; {
+		if left[i] == 0 {
+			if i == 0 {
+				return true
+			}
+			i--
+			continue
+		}
+		b := bits.TrailingZeros64(left[i])
+		left[i] &= left[i] - 1
+		w[i] = byte(b)
+		d[i+1] = p.delta[d[i]][b]
+		if i == n-1 {
+			if !fn(w) {
+				return false
+			}
+			continue
+		}
+		i++
+		left[i] = c.allowed(p, d[i], w, i)
+	}
+}
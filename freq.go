@@ -0,0 +1,236 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import "math/bits"
+
+// frequencies holds occurrence counts of the n-grams seen by LearnFrom.
+// Its layout mirrors the bitset tables of Constructor: a letter is allowed
+// by a bitset exactly when its count is non-zero.
+type frequencies struct {
+	ng4    map[uint16]*[32]uint32
+	ng3    map[uint16]*[32]uint32
+	ng3beg map[uint16]*[32]uint32
+	ng3end map[uint16]*[32]uint32
+	ng2    [32][32]uint32
+	ng1    [32]uint32
+}
+
+func newFrequencies() *frequencies {
+	return &frequencies{
+		ng4:    make(map[uint16]*[32]uint32),
+		ng3:    make(map[uint16]*[32]uint32),
+		ng3beg: make(map[uint16]*[32]uint32),
+		ng3end: make(map[uint16]*[32]uint32),
+	}
+}
+
+func (f *frequencies) clone() *frequencies {
+	g := *f
+	for _, p := range []*map[uint16]*[32]uint32{&g.ng4, &g.ng3, &g.ng3beg, &g.ng3end} {
+		m := make(map[uint16]*[32]uint32, len(*p))
+		for k, row := range *p {
+			r := *row
+			m[k] = &r
+		}
+		*p = m
+	}
+	return &g
+}
+
+func inc32(m map[uint16]*[32]uint32, index uint16, b byte) {
+	row := m[index]
+	if row == nil {
+		row = new([32]uint32)
+		m[index] = row
+	}
+	row[b]++
+}
+
+func rowSum(row *[32]uint32) float64 {
+	if row == nil {
+		return 0
+	}
+	var sum float64
+	for _, v := range row {
+		sum += float64(v)
+	}
+	return sum
+}
+
+// Collapse discards the occurrence counts collected by LearnFrom, leaving
+// only the bitset tables. Subsequent words are generated as if c had been
+// loaded from a file.
+func (c *Constructor) Collapse() {
+	c.freq = nil
+	if c.m != nil {
+		c.m.counts = nil
+	}
+}
+
+// HasFrequencies reports whether c holds occurrence counts, in which case
+// Word and WordMask pick letters in proportion to corpus frequency.
+func (c *Constructor) HasFrequencies() bool {
+	return c.freq != nil || c.m != nil && c.m.counts != nil
+}
+
+// weight returns how often letter b was seen at position i of w given w[:i].
+func (c *Constructor) weight(w []byte, i int, b byte) float64 {
+	switch {
+	case c.m != nil:
+		return c.m.weight(w, i, b)
+	case c.freq != nil:
+		return c.freq.weight(w, i, b)
+	}
+	return 0
+}
+
+// weight returns how often letter b was seen at position i of w given w[:i].
+func (f *frequencies) weight(w []byte, i int, b byte) float64 {
+	n := len(w)
+	switch {
+	case n == 1:
+		return float64(f.ng1[b])
+	case n == 2:
+		if i == 0 {
+			var sum float64
+			for _, v := range f.ng2[b] {
+				sum += float64(v)
+			}
+			return sum
+		}
+		return float64(f.ng2[w[0]][b])
+	}
+
+	beg := f.ng3beg
+	if n == 3 {
+		beg = f.ng3
+	}
+	switch i {
+	case 0:
+		var sum float64
+		for j := uint16(0); j < 32; j++ {
+			sum += rowSum(beg[uint16(b)<<5+j])
+		}
+		return sum
+	case 1:
+		return rowSum(beg[uint16(w[0])<<5+uint16(b)])
+	case 2:
+		if row := beg[uint16(w[0])<<5+uint16(w[1])]; row != nil {
+			return float64(row[b])
+		}
+		return 0
+	default:
+		if row := f.ng4[uint16(w[i-3])<<10+uint16(w[i-2])<<5+uint16(w[i-1])]; row != nil {
+			return float64(row[b])
+		}
+		return 0
+	}
+}
+
+// pick chooses one of the letters in set, in proportion to their counts if c
+// has any for this position and to the overall letter frequency otherwise.
+func (c *Constructor) pick(src *source, w []byte, i int, set uint64) byte {
+	var weights [maxLetters]float64
+	var total float64
+	if c.HasFrequencies() {
+		for s := set; s != 0; s &= s - 1 {
+			b := bits.TrailingZeros64(s)
+			weights[b] = c.weight(w, i, byte(b))
+			total += weights[b]
+		}
+	}
+	if total == 0 {
+		a := c.alphabet()
+		for s := set; s != 0; s &= s - 1 {
+			b := bits.TrailingZeros64(s)
+			weights[b] = a.weight(byte(b))
+			total += weights[b]
+		}
+	}
+
+	r := src.rand.Float64() * total
+	last := 0
+	for s := set; s != 0; s &= s - 1 {
+		b := bits.TrailingZeros64(s)
+		if r < weights[b] {
+			return byte(b)
+		}
+		r -= weights[b]
+		last = b
+	}
+	return byte(last)
+}
+
+// sample constructs a word matching p, choosing every letter among those
+// allowed by the tables. When a position runs out of letters, it backtracks
+// to the previous one. If p allows several lengths, they are tried in
+// random order. It returns nil if there is no such word.
+func (c *Constructor) sample(src *source, p *pattern) []byte {
+	nn := p.lengths()
+	for len(nn) > 0 {
+		i := src.rand.Intn(len(nn))
+		if w := c.sampleLength(src, p, nn[i]); w != nil {
+			return w
+		}
+		nn[i] = nn[len(nn)-1]
+		nn = nn[:len(nn)-1]
+	}
+	return nil
+}
+
+func (c *Constructor) sampleLength(src *source, p *pattern, n int) []byte {
+	w := make([]byte, n)
+	d := make([]int32, n+1)
+	left := make([]uint64, n)
+	left[0] = c.allowed(p, 0, w, 0)
+	for i := 0; ; {
+		if left[i] == 0 {
+			if i == 0 {
+				return nil
+			}
+			i--
+			continue
+		}
+		b := c.pick(src, w, i, left[i])
+		left[i] &^= 1 << b
+		w[i] = b
+		d[i+1] = p.delta[d[i]][b]
+		i++
+		if i == n {
+			return w
+		}
+		left[i] = c.allowed(p, d[i], w, i)
+	}
+}
+
+// walk constructs a word accepted by l, choosing every letter among those
+// that can lead to a complete word, so it never has to backtrack.
+func (l *lattice) walk(src *source) []byte {
+	w := make([]byte, l.n)
+	var st state
+	for i := 0; i < l.n; i++ {
+		var set uint64
+		for s := l.letters(w, i, st); s != 0; s &= s - 1 {
+			b := bits.TrailingZeros64(s)
+			if l.count(i+1, l.step(i, st, byte(b))) != nil {
+				set |= 1 << uint(b)
+			}
+		}
+		b := l.c.pick(src, w, i, set)
+		w[i] = b
+		st = l.step(i, st, b)
+	}
+	return w
+}
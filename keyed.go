@@ -0,0 +1,119 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/big"
+	"strings"
+)
+
+func init() {
+	DefaultConstructor.ownCache()
+}
+
+// WordFor returns the pseudo-Russian word of the specified length standing
+// for key. See Constructor.WordFor.
+func WordFor(key string, length int) string {
+	return DefaultConstructor.WordFor(key, length)
+}
+
+// WordMaskFor returns the pseudo-Russian word matching the mask standing
+// for key. See Constructor.WordMaskFor.
+func WordMaskFor(key, mask string) string {
+	return DefaultConstructor.WordMaskFor(key, mask)
+}
+
+// SetSecret sets the secret key of WordFor and WordMaskFor. Without it,
+// anyone having the tables of c can find the key a word stands for by
+// trying likely keys, such as the names of people. A nil secret restores
+// the default, an empty one.
+func (c *Constructor) SetSecret(secret []byte) {
+	c.secret = append([]byte(nil), secret...)
+	c.ownCache()
+}
+
+// WordFor returns a pseudo-word of the specified length standing for key:
+// always the same word for the same key, length, tables and secret (see
+// SetSecret), wherever WordFor runs. The word is chosen by a keyed hash of
+// the key with equal probability among all the words of that length c is
+// able to construct, so different keys may get the same word. WordFor
+// returns an empty string if there is no such word. It ignores the sampling
+// mode and ExcludeKnown.
+func (c *Constructor) WordFor(key string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return c.WordMaskFor(key, strings.Repeat(".", n))
+}
+
+// WordMaskFor is like WordFor but returns a word matching the mask. It
+// panics if the mask is invalid.
+func (c *Constructor) WordMaskFor(key, mask string) string {
+	if mask == "" {
+		return ""
+	}
+	pl, err := c.keyedPlan(mask)
+	if err != nil {
+		panic(err)
+	}
+	if err := pl.check(); err == ErrNoMatch {
+		return ""
+	} else if err != nil {
+		panic(err)
+	}
+
+	h := hmac.New(sha256.New, c.secret)
+	h.Write([]byte(mask))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	index := new(big.Int).SetBytes(h.Sum(nil))
+	return c.makeString(pl.unrank(index.Mod(index, pl.total)))
+}
+
+// keyedPlan returns the plan of the mask for c, which c keeps in its cache
+// until its tables change.
+func (c *Constructor) keyedPlan(mask string) (*plan, error) {
+	lc := c.lattices
+	if lc == nil || lc.owner != c {
+		lc = nil
+	}
+	if lc != nil {
+		lc.mu.Lock()
+		lc.reset(c.rev)
+		pl := lc.plans[mask]
+		lc.mu.Unlock()
+		if pl != nil {
+			return pl, nil
+		}
+	}
+
+	m, err := CompileMask(mask)
+	if err != nil {
+		return nil, err
+	}
+	pl := m.plan(c)
+	if lc != nil {
+		lc.mu.Lock()
+		if lc.rev == c.rev {
+			if lc.plans == nil || len(lc.plans) >= maxCachedLattices {
+				lc.plans = make(map[string]*plan)
+			}
+			lc.plans[mask] = pl
+		}
+		lc.mu.Unlock()
+	}
+	return pl, nil
+}
@@ -0,0 +1,146 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// maxAttempts limits the number of words generated in search of one that is
+// not known.
+const maxAttempts = 1000
+
+// A bloom is a Bloom filter over words in the form of letter indexes.
+type bloom struct {
+	k    uint32
+	bits []uint64
+}
+
+func newBloom(n int, p float64) *bloom {
+	if n < 1 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	return &bloom{k: uint32(k), bits: make([]uint64, int(m)/64+1)}
+}
+
+func (f *bloom) indexes(w []byte, fn func(i uint64) bool) bool {
+	h := fnv.New64a()
+	h.Write(w)
+	sum := h.Sum64()
+	h1, h2 := sum&(1<<32-1), sum>>32|1
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < uint64(f.k); i++ {
+		if !fn((h1 + i*h2) % m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloom) add(w []byte) {
+	f.indexes(w, func(i uint64) bool {
+		f.bits[i/64] |= 1 << (i % 64)
+		return true
+	})
+}
+
+func (f *bloom) has(w []byte) bool {
+	return f.indexes(w, func(i uint64) bool {
+		return f.bits[i/64]&(1<<(i%64)) != 0
+	})
+}
+
+// RememberWords makes LearnFrom remember every word it learns from in a
+// Bloom filter sized for about n words with the specified false positive
+// rate, such as 0.01. It discards the words remembered so far. The filter is
+// saved by WriteTo and restored by LoadFrom.
+func (c *Constructor) RememberWords(n int, falsePositiveRate float64) {
+	c.known = newBloom(n, falsePositiveRate)
+}
+
+// IsKnown reports whether word is one of the words c has learned from.
+// It may report a word as known when it is not with the false positive rate
+// passed to RememberWords, but never the other way around. It always
+// returns false if c does not remember words.
+func (c *Constructor) IsKnown(word string) bool {
+	if c.known == nil {
+		return false
+	}
+	w, ok := c.alphabet().indexes(word)
+	return ok && c.known.has(w)
+}
+
+// ExcludeKnown makes c avoid constructing the words it has learned from,
+// provided it remembers them (see RememberWords). If after many attempts
+// only known words turn up, Word and WordMask return an empty string and
+// their Try variants return ErrNoMatch. CountMask and CountLength still
+// include the known words.
+func (c *Constructor) ExcludeKnown(exclude bool) {
+	c.excludeKnown = exclude
+}
+
+// fresh calls gen until it returns nil or a word c does not know.
+func (c *Constructor) fresh(gen func() []byte) []byte {
+	w := gen()
+	if !c.excludeKnown || c.known == nil {
+		return w
+	}
+	for i := 1; w != nil && c.known.has(w); i++ {
+		if i == maxAttempts {
+			return nil
+		}
+		w = gen()
+	}
+	return w
+}
+
+// freshWord is like fresh with a generator calling word, which would move
+// scratch to the heap.
+func (c *Constructor) freshWord(n int, scratch []byte) []byte {
+	w := c.word(n, scratch)
+	if !c.excludeKnown || c.known == nil {
+		return w
+	}
+	for i := 1; w != nil && c.known.has(w); i++ {
+		if i == maxAttempts {
+			return nil
+		}
+		w = c.word(n, scratch)
+	}
+	return w
+}
+
+// freshWordMask is like freshWord but calls wordMask.
+func (c *Constructor) freshWordMask(bmask, scratch []byte) []byte {
+	w := c.wordMask(bmask, scratch)
+	if !c.excludeKnown || c.known == nil {
+		return w
+	}
+	for i := 1; w != nil && c.known.has(w); i++ {
+		if i == maxAttempts {
+			return nil
+		}
+		w = c.wordMask(bmask, scratch)
+	}
+	return w
+}
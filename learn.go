@@ -16,20 +16,42 @@ package rwc
 import (
 	"bufio"
 	"io"
-	"regexp"
-	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-var (
-	rWord      = regexp.MustCompile(`(?i)[а-яё]+`)
-	yoReplacer = strings.NewReplacer("ё", "е")
+// Learning selects which 4-grams of a word a Constructor learns.
+type Learning int
+
+const (
+	// AllNGrams learns every 4-gram of a word. This is the default.
+	AllNGrams Learning = iota
+
+	// FirstNGram learns only the first 4-gram of a word, as LearnFrom did
+	// in earlier versions of this package. It reproduces the sparser
+	// tables of the constructors they learned.
+	FirstNGram
+
+	// LegacyLearning marks a constructor loaded from a file that does not
+	// tell how its tables were learned: an .RWC file or a file written by
+	// an earlier version of this package, which learned like FirstNGram.
+	// Such a constructor goes on learning like FirstNGram.
+	LegacyLearning
 )
 
+// Learning returns the way c learns the 4-grams of words. LoadFrom and
+// LoadFromRWC set it from the file, or to LegacyLearning if the file does
+// not tell.
+func (c *Constructor) Learning() Learning {
+	return c.learning
+}
+
 // LearnFrom learns n-grams from an UTF-8 text it reads from r.
-// One can call LearnFrom multiple times with different readers.
+// A word is a run of letters of the alphabet of c; any other character
+// separates words. One can call LearnFrom multiple times with different
+// readers.
 func (c *Constructor) LearnFrom(r io.Reader) error {
+	c.ownCache()
 	s := bufio.NewScanner(r)
 	for s.Scan() {
 		c.process(s.Text())
@@ -54,9 +76,9 @@ func (c *Constructor) process(s string) {
 		s = s[:len(s)-size]
 	}
 
-	for _, m := range rWord.FindAllStringIndex(s, -1) {
+	for _, m := range c.alphabet().words(s) {
 		beg, end := m[0], m[1]
-		if end-beg == 2 { // 2 bytes == one russian letter
+		if utf8.RuneCountInString(s[beg:end]) == 1 {
 			// Ignore initials and such.
 			if beg > 0 {
 				r, _ := utf8.DecodeLastRuneInString(s[:beg-1])
@@ -71,37 +93,64 @@ func (c *Constructor) process(s string) {
 				}
 			}
 		}
-		c.add(yoReplacer.Replace(strings.ToLower(s[beg:end])))
+		c.add(c.alphabet().stripMarks(s[beg:end]))
 	}
 }
 
+// add learns a word of either case. It ignores a word having a character
+// that is not a letter of the alphabet.
 func (c *Constructor) add(word string) {
-	rr := []rune(word)
-	n := len(rr)
-	w := make([]byte, n)
-	for i, r := range rr {
-		w[i] = byte(r - 'а')
+	w, ok := c.alphabet().indexes(word)
+	if !ok || len(w) == 0 {
+		return
 	}
+	n := len(w)
+
+	c.rev++
+	if c.known != nil {
+		c.known.add(w)
+	}
+	if n < maxLength {
+		c.lengths[n]++
+	}
+	if c.m != nil {
+		c.m.add(w, c.learning)
+		return
+	}
+	if c.freq == nil {
+		c.freq = newFrequencies()
+	}
+	f := c.freq
 
 	switch n {
 	case 1:
 		c.ng1 |= 1 << w[0]
+		f.ng1[w[0]]++
 	case 2:
 		i := w[0]
 		c.ng2[i] |= 1 << w[1]
+		f.ng2[i][w[1]]++
 	case 3:
 		i := uint16(w[0])<<5 + uint16(w[1])
 		c.ng3[i] |= 1 << w[2]
+		inc32(f.ng3, i, w[2])
 	default:
 		i := uint16(w[0])<<5 + uint16(w[1])
 		c.ng3beg[i] |= 1 << w[2]
+		inc32(f.ng3beg, i, w[2])
 
 		i = uint16(w[n-3])<<5 + uint16(w[n-2])
 		c.ng3end[i] |= 1 << w[n-1]
+		inc32(f.ng3end, i, w[n-1])
 
-		for i := 0; i <= len(w)-4; i++ {
-			i := uint16(w[0])<<10 + uint16(w[1])<<5 + uint16(w[2])
-			c.ng4[i] |= 1 << w[3]
+		last := n - 4
+		if c.learning != AllNGrams {
+			last = 0
+		}
+		for j := 0; j <= last; j++ {
+			i := uint16(w[j])<<10 + uint16(w[j+1])<<5 + uint16(w[j+2])
+			c.ng4[i] |= 1 << w[j+3]
+			inc32(f.ng4, i, w[j+3])
 		}
 	}
 }
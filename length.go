@@ -0,0 +1,133 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+// maxLength is one more than the length of the longest word whose length is
+// recorded by LearnFrom.
+const maxLength = 64
+
+// defaultLengths is used when a constructor has not learned the lengths of
+// words from a text. The proportions are those cmd/nonstop used to pick the
+// lengths of its words.
+var defaultLengths = []float64{
+	0, 1367, 978, 718,
+	1612, 1612, 1673, 1673, 61, 61, 61, 61, 61, 61,
+}
+
+// RandomWord returns a pseudo-Russian word of random length.
+// See Constructor.RandomWord for details.
+func RandomWord() string {
+	return DefaultConstructor.RandomWord()
+}
+
+// WordBetween returns a pseudo-Russian word from min to max letters long.
+// See Constructor.WordBetween for details.
+func WordBetween(min, max int) string {
+	return DefaultConstructor.WordBetween(min, max)
+}
+
+// RandomWord returns a pseudo-Russian word whose length is drawn from the
+// distribution set with SetLengths or, failing that, from the lengths of the
+// words c has learned from. Constructors loaded from a file have no such
+// information and use the proportions cmd/nonstop has always used.
+func (c *Constructor) RandomWord() string {
+	return c.WordBetween(1, maxLength-1)
+}
+
+// WordBetween is like RandomWord but only picks lengths from min to max
+// inclusive. If none of them is likely according to the distribution, all
+// of them are equally likely. It returns an empty string if c is unable to
+// construct a word of any of those lengths.
+func (c *Constructor) WordBetween(min, max int) string {
+	if min < 1 {
+		min = 1
+	}
+	if max >= maxLength {
+		max = maxLength - 1
+	}
+	if max < min {
+		return ""
+	}
+
+	weights := make([]float64, max-min+1)
+	var total float64
+	for n := min; n <= max; n++ {
+		weights[n-min] = c.lengthWeight(n)
+		total += weights[n-min]
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	// Pick among the likely lengths, dropping every one of them c fails to
+	// construct a word of.
+	var lengths []int
+	for i, x := range weights {
+		if x > 0 {
+			lengths = append(lengths, i)
+		}
+	}
+	src := c.source()
+	for len(lengths) > 0 {
+		total = 0
+		for _, i := range lengths {
+			total += weights[i]
+		}
+		r := src.rand.Float64() * total
+		j := 0
+		for ; j < len(lengths)-1 && r >= weights[lengths[j]]; j++ {
+			r -= weights[lengths[j]]
+		}
+		if w := c.freshWord(min+lengths[j], nil); w != nil {
+			return c.makeString(w)
+		}
+		lengths = append(lengths[:j], lengths[j+1:]...)
+	}
+	return ""
+}
+
+// SetLengths sets the distribution of word lengths used by RandomWord and
+// WordBetween: weights[n] is the relative frequency of words of length n.
+// An empty slice restores the learned or the default distribution.
+func (c *Constructor) SetLengths(weights []float64) {
+	c.lengthWeights = append([]float64(nil), weights...)
+}
+
+// Lengths returns the number of words of each length c has learned from:
+// the n-th element is the number of words of length n.
+func (c *Constructor) Lengths() []uint32 {
+	n := len(c.lengths)
+	for n > 0 && c.lengths[n-1] == 0 {
+		n--
+	}
+	return append([]uint32(nil), c.lengths[:n]...)
+}
+
+func (c *Constructor) lengthWeight(n int) float64 {
+	if c.lengthWeights != nil {
+		if n < len(c.lengthWeights) {
+			return c.lengthWeights[n]
+		}
+		return 0
+	}
+	if c.lengths != [maxLength]uint32{} {
+		return float64(c.lengths[n])
+	}
+	if n < len(defaultLengths) {
+		return defaultLengths[n]
+	}
+	return 0
+}
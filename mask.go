@@ -0,0 +1,745 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"errors"
+	"math/big"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrMaskTooComplex is returned for a mask that expands into too many
+// nodes, letter positions or states, usually because of nested repetitions.
+var ErrMaskTooComplex = errors.New("mask is too complex")
+
+const (
+	maxMaskNodes     = 1 << 16
+	maxMaskPositions = 1024
+	maxMaskStates    = 1 << 14
+)
+
+// A pattern is a compiled mask: a deterministic automaton over letter
+// indexes. Masks have no unbounded repetition, so the automaton is acyclic
+// and every word it matches is at most maxLen letters long.
+type pattern struct {
+	src string
+
+	// delta[d][b] is the state reached from state d by letter b, or -1.
+	// State 0 is the initial state.
+	delta [][maxLetters]int32
+	// letters[d] is the set of letters having a transition from state d.
+	letters []uint64
+	// final[d] has bit k set if a word can end exactly k letters after d.
+	final [][]uint64
+
+	minLen, maxLen int
+}
+
+// ends reports whether a word can end exactly r letters after state d.
+func (p *pattern) ends(d int32, r int) bool {
+	f := p.final[d]
+	return r/64 < len(f) && f[r/64]&(1<<uint(r%64)) != 0
+}
+
+// allowed returns the letters leading from state d to a state from which a
+// word can end in exactly r more letters.
+func (p *pattern) allowed(d int32, r int) uint64 {
+	var set uint64
+	for s := p.letters[d]; s != 0; s &= s - 1 {
+		b := bits.TrailingZeros64(s)
+		if p.ends(p.delta[d][b], r) {
+			set |= 1 << uint(b)
+		}
+	}
+	return set
+}
+
+// lengths returns the lengths of the words matching p in increasing order.
+func (p *pattern) lengths() []int {
+	var nn []int
+	for n := p.minLen; n <= p.maxLen; n++ {
+		if p.ends(0, n) {
+			nn = append(nn, n)
+		}
+	}
+	return nn
+}
+
+// lengthPattern returns a pattern matching any word of length n.
+func lengthPattern(a *Alphabet, n int) *pattern {
+	bmask := make([]byte, n)
+	for i := range bmask {
+		bmask[i] = maskAny
+	}
+	return linearPattern(strings.Repeat(".", n), bmask, a)
+}
+
+// linearPattern returns a pattern matching the mask in its original form.
+func linearPattern(src string, bmask []byte, a *Alphabet) *pattern {
+	n := len(bmask)
+	p := &pattern{
+		src:     src,
+		delta:   make([][maxLetters]int32, n+1),
+		letters: make([]uint64, n+1),
+		final:   make([][]uint64, n+1),
+		minLen:  n,
+		maxLen:  n,
+	}
+	width := n/64 + 1
+	final := make([]uint64, (n+1)*width)
+	for d := 0; d <= n; d++ {
+		for b := range p.delta[d] {
+			p.delta[d][b] = -1
+		}
+		if d < n {
+			p.letters[d] = a.maskSet(bmask[d])
+			for s := p.letters[d]; s != 0; s &= s - 1 {
+				p.delta[d][bits.TrailingZeros64(s)] = int32(d + 1)
+			}
+		}
+		r := n - d
+		p.final[d] = final[d*width : (d+1)*width]
+		p.final[d][r/64] = 1 << uint(r%64)
+	}
+	return p
+}
+
+// simpleMask returns the mask in its original form if it consists of
+// letters of the alphabet, V, C and dots only, or nil.
+func simpleMask(mask string, a *Alphabet) []byte {
+	return appendSimpleMask(make([]byte, 0, len(mask)), mask, a)
+}
+
+// appendSimpleMask is like simpleMask but appends the mask to bmask.
+func appendSimpleMask(bmask []byte, mask string, a *Alphabet) []byte {
+	for _, r := range mask {
+		switch r {
+		case '.', 'V', 'C':
+			bmask = append(bmask, 0x80|byte(r))
+			continue
+		}
+		b, ok := a.letterIndex(r)
+		if !ok {
+			return nil
+		}
+		bmask = append(bmask, b)
+	}
+	return bmask
+}
+
+// A maskNode is a node of the syntax tree of a mask.
+type maskNode struct {
+	op   byte // 'l' for a letter set, 's' for a sequence, '|' for alternatives, '?' for an optional node
+	set  uint64
+	kids []*maskNode
+	size int // see expanded
+}
+
+// expanded returns the number of nodes of the tree rooted at node, counting
+// the nodes shared by repetitions every time they repeat, which is the
+// number of nodes glushkov.build visits.
+func (node *maskNode) expanded() int {
+	if node.size == 0 {
+		node.size = 1
+		for _, kid := range node.kids {
+			node.size += kid.expanded()
+		}
+	}
+	return node.size
+}
+
+// A maskParser parses a mask into a syntax tree. Without an alphabet, it
+// only checks the syntax: any letter is accepted and every letter set is
+// the same.
+type maskParser struct {
+	s   string
+	pos int
+	abc *Alphabet
+}
+
+func (mp *maskParser) errorAt(off int) error {
+	if off >= len(mp.s) {
+		r, _ := utf8.DecodeLastRuneInString(mp.s)
+		return &MaskError{Rune: r, Offset: len(mp.s) - utf8.RuneLen(r)}
+	}
+	r, _ := utf8.DecodeRuneInString(mp.s[off:])
+	return &MaskError{Rune: r, Offset: off}
+}
+
+func (mp *maskParser) peek() rune {
+	if mp.pos >= len(mp.s) {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(mp.s[mp.pos:])
+	return r
+}
+
+func (mp *maskParser) advance() rune {
+	r, size := utf8.DecodeRuneInString(mp.s[mp.pos:])
+	mp.pos += size
+	return r
+}
+
+// letterIndex returns the index of a letter of the alphabet.
+func (mp *maskParser) letterIndex(r rune) (byte, bool) {
+	if mp.abc == nil {
+		return 0, unicode.IsLetter(r)
+	}
+	return mp.abc.letterIndex(r)
+}
+
+// maskSet returns the letters matched by a letter index or a mask symbol.
+func (mp *maskParser) maskSet(how byte) uint64 {
+	if mp.abc == nil {
+		return 1
+	}
+	return mp.abc.maskSet(how)
+}
+
+// alternatives parses alternatives separated by '|'.
+func (mp *maskParser) alternatives() (*maskNode, error) {
+	var alts []*maskNode
+	for {
+		seq, err := mp.sequence()
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, seq)
+		if mp.peek() != '|' {
+			break
+		}
+		mp.advance()
+	}
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+	return &maskNode{op: '|', kids: alts}, nil
+}
+
+func (mp *maskParser) sequence() (*maskNode, error) {
+	seq := &maskNode{op: 's'}
+	for {
+		switch r := mp.peek(); r {
+		case -1, '|', ')':
+			return seq, nil
+		case '?', '{':
+			if len(seq.kids) == 0 {
+				return nil, mp.errorAt(mp.pos)
+			}
+			last := &seq.kids[len(seq.kids)-1]
+			var err error
+			if *last, err = mp.quantifier(*last); err != nil {
+				return nil, err
+			}
+		default:
+			atom, err := mp.atom()
+			if err != nil {
+				return nil, err
+			}
+			seq.kids = append(seq.kids, atom)
+		}
+	}
+}
+
+func (mp *maskParser) atom() (*maskNode, error) {
+	off := mp.pos
+	r := mp.advance()
+	switch r {
+	case '.', 'V', 'C':
+		return &maskNode{op: 'l', set: mp.maskSet(0x80 | byte(r))}, nil
+	case '[':
+		return mp.class(off)
+	case '(':
+		alt, err := mp.alternatives()
+		if err != nil {
+			return nil, err
+		}
+		if mp.peek() != ')' {
+			return nil, mp.errorAt(off)
+		}
+		mp.advance()
+		return alt, nil
+	}
+	if b, ok := mp.letterIndex(r); ok {
+		return &maskNode{op: 'l', set: mp.maskSet(b)}, nil
+	}
+	return nil, mp.errorAt(off)
+}
+
+// class parses a bracketed character class such as [бвг], [^ьъ] or [а-е].
+func (mp *maskParser) class(open int) (*maskNode, error) {
+	negate := false
+	if mp.peek() == '^' {
+		mp.advance()
+		negate = true
+	}
+	var set uint64
+	for {
+		off := mp.pos
+		r := mp.peek()
+		if r == -1 {
+			return nil, mp.errorAt(open)
+		}
+		mp.advance()
+		if r == ']' {
+			break
+		}
+		lo, ok := mp.letterIndex(r)
+		if !ok {
+			return nil, mp.errorAt(off)
+		}
+		hi := lo
+		if mp.peek() == '-' {
+			mp.advance()
+			off := mp.pos
+			r := mp.peek()
+			if r == -1 {
+				return nil, mp.errorAt(open)
+			}
+			mp.advance()
+			if hi, ok = mp.letterIndex(r); !ok || hi < lo {
+				return nil, mp.errorAt(off)
+			}
+		}
+		for b := lo; b <= hi; b++ {
+			set |= mp.maskSet(b)
+		}
+	}
+	if set == 0 {
+		// An empty class, like [] or [^], matches nothing with or
+		// without an alphabet.
+		return nil, mp.errorAt(open)
+	}
+	if negate && mp.abc != nil {
+		set = mp.abc.all &^ set
+	}
+	if set == 0 {
+		return nil, mp.errorAt(open)
+	}
+	return &maskNode{op: 'l', set: set}, nil
+}
+
+// quantifier applies '?' or {m}, {m,n} to node.
+func (mp *maskParser) quantifier(node *maskNode) (*maskNode, error) {
+	off := mp.pos
+	if mp.advance() == '?' {
+		return &maskNode{op: '?', kids: []*maskNode{node}}, nil
+	}
+
+	end := strings.IndexByte(mp.s[mp.pos:], '}')
+	if end < 0 {
+		return nil, mp.errorAt(off)
+	}
+	spec := mp.s[mp.pos : mp.pos+end]
+	mp.pos += end + 1
+	lo, hi := spec, spec
+	if i := strings.IndexByte(spec, ','); i >= 0 {
+		lo, hi = spec[:i], spec[i+1:]
+	}
+	m, err1 := strconv.Atoi(lo)
+	n, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || m < 0 || n < m || n > maxMaskPositions {
+		return nil, mp.errorAt(off)
+	}
+
+	// x{2,4} is x x (x x?)?
+	var tail *maskNode
+	for i := m; i < n; i++ {
+		kids := []*maskNode{node}
+		if tail != nil {
+			kids = append(kids, tail)
+		}
+		tail = &maskNode{op: '?', kids: []*maskNode{{op: 's', kids: kids}}}
+	}
+	seq := &maskNode{op: 's'}
+	for i := 0; i < m; i++ {
+		seq.kids = append(seq.kids, node)
+	}
+	if tail != nil {
+		seq.kids = append(seq.kids, tail)
+	}
+	if seq.expanded() > maxMaskNodes {
+		return nil, ErrMaskTooComplex
+	}
+	return seq, nil
+}
+
+// glushkov builds a position automaton: every letter set of the expanded
+// mask is a position, and follow lists the positions that may come next.
+type glushkov struct {
+	sets   []uint64
+	follow [][]int
+	last   []bool
+}
+
+type fragment struct {
+	first, last []int
+	nullable    bool
+}
+
+func (g *glushkov) build(node *maskNode) (fragment, error) {
+	switch node.op {
+	case 'l':
+		if len(g.sets) == maxMaskPositions {
+			return fragment{}, ErrMaskTooComplex
+		}
+		q := len(g.sets)
+		g.sets = append(g.sets, node.set)
+		g.follow = append(g.follow, nil)
+		return fragment{first: []int{q}, last: []int{q}}, nil
+	case '?':
+		f, err := g.build(node.kids[0])
+		f.nullable = true
+		return f, err
+	case '|':
+		var f fragment
+		for _, kid := range node.kids {
+			k, err := g.build(kid)
+			if err != nil {
+				return f, err
+			}
+			f.first = append(f.first, k.first...)
+			f.last = append(f.last, k.last...)
+			f.nullable = f.nullable || k.nullable
+		}
+		return f, nil
+	}
+
+	f := fragment{nullable: true}
+	for _, kid := range node.kids {
+		k, err := g.build(kid)
+		if err != nil {
+			return f, err
+		}
+		for _, q := range f.last {
+			g.follow[q] = append(g.follow[q], k.first...)
+		}
+		if f.nullable {
+			f.first = append(f.first, k.first...)
+		}
+		if k.nullable {
+			f.last = append(f.last, k.last...)
+		} else {
+			f.last = k.last
+		}
+		f.nullable = f.nullable && k.nullable
+	}
+	return f, nil
+}
+
+// parseMask parses a mask into a syntax tree. Letters are looked up in the
+// alphabet; if it is nil, only the syntax is checked.
+func parseMask(mask string, a *Alphabet) (*maskNode, error) {
+	mp := &maskParser{s: mask, abc: a}
+	root, err := mp.alternatives()
+	if err != nil {
+		return nil, err
+	}
+	if mp.pos < len(mask) {
+		return nil, mp.errorAt(mp.pos)
+	}
+	if root.expanded() > maxMaskNodes {
+		return nil, ErrMaskTooComplex
+	}
+	return root, nil
+}
+
+// compileMask parses a mask and turns it into a deterministic automaton
+// over the letters of the alphabet.
+func compileMask(mask string, a *Alphabet) (*pattern, error) {
+	if bmask := simpleMask(mask, a); len(bmask) > 0 {
+		return linearPattern(mask, bmask, a), nil
+	}
+
+	root, err := parseMask(mask, a)
+	if err != nil {
+		return nil, err
+	}
+
+	g := new(glushkov)
+	f, err := g.build(root)
+	if err != nil {
+		return nil, err
+	}
+	g.last = make([]bool, len(g.sets))
+	for _, q := range f.last {
+		g.last[q] = true
+	}
+
+	p := &pattern{src: mask}
+	if err := p.determinize(g, f.first); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// determinize performs the subset construction. Positions only ever
+// follow positions with smaller numbers, so the result is acyclic.
+func (p *pattern) determinize(g *glushkov, first []int) error {
+	index := make(map[string]int32)
+	var subsets [][]int
+	accepting := []bool{false}
+	add := func(s []int) (int32, error) {
+		sort.Ints(s)
+		u := s[:0]
+		for i, q := range s {
+			if i == 0 || q != s[i-1] {
+				u = append(u, q)
+			}
+		}
+		key := make([]byte, 0, 2*len(u))
+		for _, q := range u {
+			key = append(key, byte(q), byte(q>>8))
+		}
+		if d, ok := index[string(key)]; ok {
+			return d, nil
+		}
+		if len(subsets) == maxMaskStates {
+			return 0, ErrMaskTooComplex
+		}
+		d := int32(len(subsets))
+		index[string(key)] = d
+		subsets = append(subsets, u)
+		acc := false
+		for _, q := range u {
+			acc = acc || g.last[q]
+		}
+		accepting = append(accepting, acc)
+		return d, nil
+	}
+
+	// Subset 0 stands for the initial state; its candidates are first.
+	subsets = append(subsets, nil)
+	for d := 0; d < len(subsets); d++ {
+		cand := first
+		if d > 0 {
+			cand = nil
+			for _, q := range subsets[d] {
+				cand = append(cand, g.follow[q]...)
+			}
+		}
+		var row [maxLetters]int32
+		var letters uint64
+		for b := range row {
+			var s []int
+			for _, q := range cand {
+				if g.sets[q]&(1<<uint(b)) != 0 {
+					s = append(s, q)
+				}
+			}
+			row[b] = -1
+			if s != nil {
+				t, err := add(s)
+				if err != nil {
+					return err
+				}
+				row[b] = t
+				letters |= 1 << uint(b)
+			}
+		}
+		p.delta = append(p.delta, row)
+		p.letters = append(p.letters, letters)
+	}
+
+	// Every position only follows positions with smaller numbers, so a
+	// transition always leads to a subset with a larger smallest position.
+	// Visiting the subsets in decreasing order of it computes final
+	// bottom-up.
+	order := make([]int, len(subsets))
+	for d := range order {
+		order[d] = d
+	}
+	minPos := func(d int) int {
+		if d == 0 {
+			return -1
+		}
+		return subsets[d][0]
+	}
+	sort.Slice(order, func(i, j int) bool { return minPos(order[i]) > minPos(order[j]) })
+
+	width := len(g.sets)/64 + 1
+	p.final = make([][]uint64, len(subsets))
+	for _, d := range order {
+		f := make([]uint64, width)
+		if accepting[d] && d > 0 {
+			f[0] = 1
+		}
+		for s := p.letters[d]; s != 0; s &= s - 1 {
+			t := p.final[p.delta[d][bits.TrailingZeros64(s)]]
+			var carry uint64
+			for i := range f {
+				f[i] |= t[i]<<1 | carry
+				carry = t[i] >> 63
+			}
+		}
+		p.final[d] = f
+	}
+
+	p.minLen, p.maxLen = 0, -1
+	for n := 1; n <= len(g.sets); n++ {
+		if p.ends(0, n) {
+			if p.minLen == 0 {
+				p.minLen = n
+			}
+			p.maxLen = n
+		}
+	}
+	return nil
+}
+
+// A Mask is a compiled mask. Compiling a mask once and generating words
+// with it is faster than calling WordMask repeatedly, since a Mask
+// remembers which letters can lead to a complete word for every
+// constructor it was used with. A Mask is safe for concurrent use.
+type Mask struct {
+	src string
+
+	mu       sync.Mutex
+	patterns map[*Alphabet]*pattern
+	plans    map[*Constructor]*plan
+}
+
+// A plan holds the lattices of a mask for one revision of a constructor.
+type plan struct {
+	rev      uint64
+	err      error      // the mask does not fit the alphabet
+	lattices []*lattice // only the lengths having accepted words
+	total    *big.Int
+}
+
+const maxMaskPlans = 16
+
+// CompileMask parses a mask. See WordMask for the mask syntax. Since the
+// mask may be used with constructors having different alphabets, any
+// letter is accepted; a letter missing from the alphabet of a constructor
+// is reported by Prepare and Generate.
+func CompileMask(mask string) (*Mask, error) {
+	if _, err := parseMask(mask, nil); err != nil {
+		return nil, err
+	}
+	return &Mask{src: mask}, nil
+}
+
+// MustCompileMask is like CompileMask but panics if the mask is invalid.
+func MustCompileMask(mask string) *Mask {
+	m, err := CompileMask(mask)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// String returns the source text of the mask.
+func (m *Mask) String() string {
+	return m.src
+}
+
+func (m *Mask) plan(c *Constructor) *plan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pl := m.plans[c]; pl != nil && pl.rev == c.rev {
+		return pl
+	}
+	if m.plans == nil || len(m.plans) >= maxMaskPlans {
+		m.plans = make(map[*Constructor]*plan)
+	}
+	pl := &plan{rev: c.rev, total: new(big.Int)}
+	m.plans[c] = pl
+
+	a := c.alphabet()
+	p := m.patterns[a]
+	if p == nil {
+		if p, pl.err = compileMask(m.src, a); pl.err != nil {
+			return pl
+		}
+		if m.patterns == nil {
+			m.patterns = make(map[*Alphabet]*pattern)
+		}
+		m.patterns[a] = p
+	}
+	for _, l := range c.buildLattices(p, p.lengths()) {
+		if cnt := l.total(); cnt.Sign() > 0 {
+			pl.lattices = append(pl.lattices, l)
+			pl.total.Add(pl.total, cnt)
+		}
+	}
+	return pl
+}
+
+// Prepare precomputes which letters can lead to a complete word for c and
+// returns ErrNoMatch if no word that c is able to construct matches the
+// mask, or a *MaskError if the mask has a letter missing from the alphabet
+// of c. Generate calls it if needed; Prepare only makes the cost up front.
+func (m *Mask) Prepare(c *Constructor) error {
+	return m.plan(c).check()
+}
+
+func (pl *plan) check() error {
+	if pl.err != nil {
+		return pl.err
+	}
+	if len(pl.lattices) == 0 {
+		return ErrNoMatch
+	}
+	return nil
+}
+
+// Count returns the number of distinct words matching the mask that c is
+// able to construct.
+func (m *Mask) Count(c *Constructor) *big.Int {
+	return new(big.Int).Set(m.plan(c).total)
+}
+
+// Generate returns a word matching the mask constructed by c, or an error
+// as described for Prepare. Unlike WordMask, it never runs into dead ends,
+// so its running time depends only on the length of the word.
+func (m *Mask) Generate(c *Constructor) (string, error) {
+	pl := m.plan(c)
+	if err := pl.check(); err != nil {
+		return "", err
+	}
+	w := c.fresh(func() []byte { return pl.generate(c) })
+	if w == nil {
+		return "", ErrNoMatch
+	}
+	return c.makeString(w), nil
+}
+
+func (pl *plan) generate(c *Constructor) []byte {
+	src := c.source()
+	if c.sampling == Uniform {
+		return pl.unrank(new(big.Int).Rand(src.rand, pl.total))
+	}
+	return pl.lattices[src.rand.Intn(len(pl.lattices))].walk(src)
+}
+
+// unrank returns the word with the specified index, less than pl.total, in
+// the list of the words accepted by the lattices of pl in turn.
+func (pl *plan) unrank(index *big.Int) []byte {
+	r := new(big.Int).Set(index)
+	for _, l := range pl.lattices {
+		cnt := l.total()
+		if r.Cmp(cnt) < 0 {
+			return l.unrank(r)
+		}
+		r.Sub(r, cnt)
+	}
+	return nil
+}
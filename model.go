@@ -0,0 +1,416 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// Indexes of the tables of a model. They match the tables of Constructor,
+// and a model of order n has tables up to ng<n> after ng4.
+const (
+	tabNg1 = iota
+	tabNg2
+	tabNg3
+	tabNg3beg
+	tabNg3end
+	tabNg4
+	numTables // of a model of order 4
+
+	// tabEnd+k is the table of the letter k+1 positions from the end
+	// of a word.
+	tabEnd = 64
+)
+
+const (
+	minOrder     = 4
+	maxOrder     = 8
+	maxSuffixLen = 8
+)
+
+// tableName returns the name of a table as used by Rejection.
+func tableName(table int) string {
+	if table >= tabEnd {
+		return "end" + strconv.Itoa(table-tabEnd+1)
+	}
+	if table >= tabNg4 {
+		return "ng" + strconv.Itoa(table-tabNg4+4)
+	}
+	return [...]string{"ng1", "ng2", "ng3", "ng3beg", "ng3end"}[table]
+}
+
+// A model holds the n-gram tables of a constructor whose alphabet or order
+// does not fit the tables of Constructor. The tables are the same, but they
+// are sparse, they are keyed by letters packed six bits each, and their
+// letter sets have room for 64 letters. A model of order n also has tables
+// of the letters following every k letters for k from 4 to n-1, which it
+// consults first, backing off to the shorter contexts it has not seen.
+//
+// A model may also have the tables of the letters following every three
+// letters at each of the last few positions of a word: ends[0] for the
+// last letter, ends[1] for the one before it and so on.
+type model struct {
+	all    uint64 // the letters of the alphabet
+	tables []map[uint64]uint64
+	ends   []map[uint64]uint64
+
+	// counts holds occurrence counts like frequencies does for the
+	// classic tables. It is nil unless the model has learned from a text.
+	counts map[countKey]*[maxLetters]uint32
+}
+
+// A countKey identifies the letters preceding a letter whose occurrences
+// are counted: the table and the last depth letters packed into ctx.
+type countKey struct {
+	table uint8
+	depth uint8
+	ctx   uint64
+}
+
+func newModel(a *Alphabet, order, suffixLen int) *model {
+	m := &model{
+		all:    a.all,
+		tables: make([]map[uint64]uint64, numTables+order-minOrder),
+		ends:   make([]map[uint64]uint64, suffixLen),
+	}
+	for i := range m.tables {
+		m.tables[i] = make(map[uint64]uint64)
+	}
+	for i := range m.ends {
+		m.ends[i] = make(map[uint64]uint64)
+	}
+	return m
+}
+
+func (m *model) clone() *model {
+	d := &model{all: m.all, tables: cloneTables(m.tables), ends: cloneTables(m.ends)}
+	if m.counts != nil {
+		d.counts = make(map[countKey]*[maxLetters]uint32, len(m.counts))
+		for k, row := range m.counts {
+			r := *row
+			d.counts[k] = &r
+		}
+	}
+	return d
+}
+
+func cloneTables(tables []map[uint64]uint64) []map[uint64]uint64 {
+	dst := make([]map[uint64]uint64, len(tables))
+	for i, t := range tables {
+		dst[i] = make(map[uint64]uint64, len(t))
+		for k, s := range t {
+			dst[i][k] = s
+		}
+	}
+	return dst
+}
+
+func (m *model) order() int {
+	return len(m.tables) - numTables + minOrder
+}
+
+// mid returns the table of the letters following k letters.
+func mid(k int) int {
+	return tabNg4 + k - 3
+}
+
+// pack packs letters six bits each, the last letter in the lowest bits.
+func pack(w []byte) uint64 {
+	var x uint64
+	for _, b := range w {
+		x = x<<6 | uint64(b)
+	}
+	return x
+}
+
+func (m *model) set(table int, ctx uint64, b byte) {
+	m.tables[table][ctx] |= 1 << b
+}
+
+func (m *model) count(table int, w []byte, b byte) {
+	if m.counts == nil {
+		m.counts = make(map[countKey]*[maxLetters]uint32)
+	}
+	k := countKey{uint8(table), uint8(len(w)), pack(w)}
+	row := m.counts[k]
+	if row == nil {
+		row = new([maxLetters]uint32)
+		m.counts[k] = row
+	}
+	row[b]++
+}
+
+// add learns a word the way l says. Unlike Constructor.add, it counts the
+// first letters of the word along with the others.
+func (m *model) add(w []byte, l Learning) {
+	n := len(w)
+	switch n {
+	case 1:
+		m.set(tabNg1, 0, w[0])
+		m.count(tabNg1, nil, w[0])
+	case 2:
+		m.set(tabNg2, uint64(w[0]), w[1])
+		for i := range w {
+			m.count(tabNg2, w[:i], w[i])
+		}
+	default:
+		table := tabNg3beg
+		if n == 3 {
+			table = tabNg3
+		}
+		m.set(table, pack(w[:2]), w[2])
+		for i := 0; i < 3; i++ {
+			m.count(table, w[:i], w[i])
+		}
+		if n == 3 {
+			return
+		}
+
+		m.set(tabNg3end, pack(w[n-3:n-1]), w[n-1])
+		last := n - 1
+		if l != AllNGrams {
+			last = 3
+		}
+		for i := 3; i <= last; i++ {
+			for k := 3; k < m.order() && k <= i; k++ {
+				m.set(mid(k), pack(w[i-k:i]), w[i])
+				m.count(mid(k), w[i-k:i], w[i])
+			}
+		}
+		// The suffix tables learn the end of every word whatever l is.
+		for k := 0; k < len(m.ends) && n-1-k >= 3; k++ {
+			i := n - 1 - k
+			m.ends[k][pack(w[i-3:i])] |= 1 << w[i]
+		}
+	}
+}
+
+// next returns the set of letters allowed at position i of w given w[:i].
+// It follows Constructor.next.
+func (m *model) next(w []byte, i int) uint64 {
+	n := len(w)
+	switch {
+	case n == 1:
+		return m.tables[tabNg1][0]
+	case n == 2:
+		if i == 1 {
+			return m.tables[tabNg2][uint64(w[0])]
+		}
+	case i < 2:
+	case i == 2:
+		if n == 3 {
+			return m.tables[tabNg3][pack(w[:2])]
+		}
+		return m.tables[tabNg3beg][pack(w[:2])]
+	case i == n-1:
+		return m.tables[tabNg3end][pack(w[n-3:n-1])] & m.follow(w, i) & m.end(w, i)
+	default:
+		return m.follow(w, i) & m.end(w, i)
+	}
+	return m.all
+}
+
+// end returns the letters allowed at position i of w, i >= 3, by the
+// table of that position from the end, if the model has one.
+func (m *model) end(w []byte, i int) uint64 {
+	if k := len(w) - 1 - i; k < len(m.ends) {
+		return m.ends[k][pack(w[i-3:i])]
+	}
+	return m.all
+}
+
+// contextLen returns the number of letters before position i of w, at
+// least three, that the model has seen followed by some letter. It looks
+// at most order-1 letters back.
+func (m *model) contextLen(w []byte, i int) int {
+	k := m.order() - 1
+	if k > i {
+		k = i
+	}
+	for ; k > 3; k-- {
+		if m.tables[mid(k)][pack(w[i-k:i])] != 0 {
+			break
+		}
+	}
+	return k
+}
+
+// follow returns the letters allowed after the longest context of letter i
+// of w, i >= 3.
+func (m *model) follow(w []byte, i int) uint64 {
+	k := m.contextLen(w, i)
+	return m.tables[mid(k)][pack(w[i-k:i])]
+}
+
+// rejectedBy returns the table that does not allow letter i of w, provided
+// next does not allow it.
+func (m *model) rejectedBy(w []byte, i int) int {
+	n := len(w)
+	switch {
+	case n == 1:
+		return tabNg1
+	case n == 2:
+		return tabNg2
+	case n == 3:
+		return tabNg3
+	case i == 2:
+		return tabNg3beg
+	case i == n-1 && m.tables[tabNg3end][pack(w[n-3:n-1])]&(1<<w[n-1]) == 0:
+		return tabNg3end
+	case m.follow(w, i)&(1<<w[i]) != 0:
+		return tabEnd + n - 1 - i
+	}
+	return mid(m.contextLen(w, i))
+}
+
+// weight returns how often letter b was seen at position i of w given w[:i].
+func (m *model) weight(w []byte, i int, b byte) float64 {
+	n := len(w)
+	var k countKey
+	switch {
+	case n == 1:
+		k = countKey{tabNg1, 0, 0}
+	case n == 2:
+		k = countKey{tabNg2, uint8(i), pack(w[:i])}
+	case i < 3:
+		table := tabNg3beg
+		if n == 3 {
+			table = tabNg3
+		}
+		k = countKey{uint8(table), uint8(i), pack(w[:i])}
+	default:
+		j := m.contextLen(w, i)
+		k = countKey{uint8(mid(j)), uint8(j), pack(w[i-j : i])}
+	}
+	if row := m.counts[k]; row != nil {
+		return float64(row[b])
+	}
+	return 0
+}
+
+// importTables fills the model from the tables of c, which hold letter
+// slots[i] in slot i. The n-grams having a slot that is 0xff are dropped.
+func (m *model) importTables(c *Constructor, slots []byte) {
+	set := func(bits uint32) uint64 {
+		var s uint64
+		for i, b := range slots {
+			if bits&(1<<uint(i)) != 0 && b != 0xff {
+				s |= 1 << b
+			}
+		}
+		return s
+	}
+	put := func(table int, bits uint32, ctx ...int) {
+		if bits == 0 {
+			return
+		}
+		w := make([]byte, len(ctx))
+		for i, slot := range ctx {
+			if w[i] = slots[slot]; w[i] == 0xff {
+				return
+			}
+		}
+		if s := set(bits); s != 0 {
+			m.tables[table][pack(w)] |= s
+		}
+	}
+
+	put(tabNg1, c.ng1)
+	for i, bits := range c.ng2 {
+		put(tabNg2, bits, i)
+	}
+	for i := range c.ng3 {
+		put(tabNg3, c.ng3[i], i>>5, i&31)
+		put(tabNg3beg, c.ng3beg[i], i>>5, i&31)
+		put(tabNg3end, c.ng3end[i], i>>5, i&31)
+	}
+	for i, bits := range c.ng4 {
+		put(tabNg4, bits, i>>10, i>>5&31, i&31)
+	}
+}
+
+// marshal encodes the tables as the number of tables followed by
+// every table: the number of its entries and the entries ordered by key,
+// each of them a key and a letter set. The tables of the positions at the
+// end of words, if any, follow in the same way.
+func (m *model) marshal() []byte {
+	buf := new(bytes.Buffer)
+	writeTables(buf, m.tables)
+	if len(m.ends) > 0 {
+		writeTables(buf, m.ends)
+	}
+	return buf.Bytes()
+}
+
+func writeTables(buf *bytes.Buffer, tables []map[uint64]uint64) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(tables)))
+	for _, t := range tables {
+		keys := make([]uint64, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		binary.Write(buf, binary.LittleEndian, uint32(len(keys)))
+		for _, k := range keys {
+			binary.Write(buf, binary.LittleEndian, [2]uint64{k, t[k]})
+		}
+	}
+}
+
+func (m *model) unmarshal(data []byte) error {
+	r := bytes.NewReader(data)
+	var err error
+	if m.tables, err = m.readTables(r, numTables, numTables+maxOrder-minOrder); err != nil {
+		return err
+	}
+	m.ends = nil
+	if r.Len() > 0 {
+		m.ends, err = m.readTables(r, 1, maxSuffixLen)
+	}
+	return err
+}
+
+func (m *model) readTables(r *bytes.Reader, min, max uint32) ([]map[uint64]uint64, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n < min || n > max {
+		return nil, errors.New("invalid model section")
+	}
+	tables := make([]map[uint64]uint64, n)
+	for i := range tables {
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if uint64(size)*16 > uint64(r.Len()) {
+			return nil, errors.New("invalid model section")
+		}
+		entries := make([][2]uint64, size)
+		if err := binary.Read(r, binary.LittleEndian, entries); err != nil {
+			return nil, err
+		}
+		t := make(map[uint64]uint64, size)
+		for _, e := range entries {
+			t[e[0]] = e[1] & m.all
+		}
+		tables[i] = t
+	}
+	return tables, nil
+}
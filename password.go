@@ -0,0 +1,129 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// PasswordOptions configure Password.
+type PasswordOptions struct {
+	// Mask is the mask every word of the password matches. See WordMask
+	// for the mask syntax. The default is "CVCVCV".
+	Mask string
+
+	// Entropy is the least entropy of the password in bits, a finite
+	// number that is not negative. The default is 64.
+	Entropy float64
+
+	// Separators are the characters joining the words, one of them
+	// between every two words. They must not be letters of the alphabet,
+	// so that the password splits back into its words. The default is the
+	// ten digits.
+	Separators string
+}
+
+// Password returns a password made by DefaultConstructor and its entropy.
+func Password(opts PasswordOptions) (string, float64, error) {
+	return DefaultConstructor.Password(opts)
+}
+
+// Password returns a password made of words matching opts.Mask joined with
+// opts.Separators, having as many words as it takes to reach opts.Entropy,
+// and its entropy in bits: the base-2 logarithm of the number of passwords
+// of that many words Password could have made, all of them equally likely.
+// Unlike Word, it draws random numbers from crypto/rand and picks every
+// word with equal probability among all the words matching the mask that c
+// is able to construct, so the entropy is known exactly. That only holds as
+// long as every password splits into its words and separators in one way,
+// which is why the separators may not be letters of the alphabet of c. It
+// ignores ExcludeKnown, which would make the entropy smaller.
+//
+// Password returns ErrNoMatch if no word matches the mask, a *MaskError if
+// the mask is invalid and another error if the entropy is not a finite
+// number that is not negative, the separators repeat or are letters, or the
+// words and the separators cannot reach the entropy.
+func (c *Constructor) Password(opts PasswordOptions) (string, float64, error) {
+	mask, target, seps := opts.Mask, opts.Entropy, []rune(opts.Separators)
+	if mask == "" {
+		mask = "CVCVCV"
+	}
+	if math.IsNaN(target) || math.IsInf(target, 0) || target < 0 {
+		return "", 0, fmt.Errorf("invalid entropy %v", target)
+	}
+	if target == 0 {
+		target = 64
+	}
+	if len(seps) == 0 {
+		seps = []rune("0123456789")
+	}
+	for i, r := range seps {
+		if strings.ContainsRune(string(seps[i+1:]), r) {
+			return "", 0, fmt.Errorf("separator %q repeats", r)
+		}
+		if _, ok := c.alphabet().letterIndex(r); ok {
+			return "", 0, fmt.Errorf("separator %q is a letter", r)
+		}
+	}
+
+	m, err := CompileMask(mask)
+	if err != nil {
+		return "", 0, err
+	}
+	pl := m.plan(c)
+	if err := pl.check(); err != nil {
+		return "", 0, err
+	}
+	wordBits := log2(pl.total)
+	sepBits := math.Log2(float64(len(seps)))
+	if wordBits == 0 && sepBits == 0 {
+		return "", 0, errors.New("password cannot reach the entropy")
+	}
+
+	var sb strings.Builder
+	var entropy float64
+	for words := 0; words == 0 || entropy < target; words++ {
+		if words > 0 {
+			i, err := rand.Int(rand.Reader, big.NewInt(int64(len(seps))))
+			if err != nil {
+				return "", 0, err
+			}
+			sb.WriteRune(seps[i.Int64()])
+			entropy += sepBits
+		}
+		i, err := rand.Int(rand.Reader, pl.total)
+		if err != nil {
+			return "", 0, err
+		}
+		sb.WriteString(c.makeString(pl.unrank(i)))
+		entropy += wordBits
+	}
+	return sb.String(), entropy, nil
+}
+
+// log2 returns the base-2 logarithm of x > 0.
+func log2(x *big.Int) float64 {
+	var shift int
+	if n := x.BitLen(); n > 64 {
+		shift = n - 64
+		x = new(big.Int).Rsh(x, uint(shift))
+	}
+	f, _ := new(big.Float).SetInt(x).Float64()
+	return math.Log2(f) + float64(shift)
+}
@@ -0,0 +1,165 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pseudonymize copies a text from r to w replacing its words with
+// pseudo-Russian words. See Constructor.Pseudonymize.
+func Pseudonymize(w io.Writer, r io.Reader) error {
+	return DefaultConstructor.Pseudonymize(w, r)
+}
+
+// Pseudonymize copies an UTF-8 text from r to w replacing every word, a run
+// of letters of the alphabet of c as for LearnFrom, with a pseudo-word of
+// the same length, case pattern and sequence of vowels and consonants;
+// other letters, such as 'ь' and 'ъ' in Russian, stay in place. Everything
+// else, including the words having letters of other alphabets, like
+// "Wi-Fi" or "iPhone", stays as it is. The same word, in any case, gets the
+// same pseudo-word throughout the text, and different words get different
+// pseudo-words, none of them the word itself, as long as c is able to
+// construct enough of them; otherwise the letters are picked at random,
+// which may fail to avoid the word itself for the shortest words.
+func (c *Constructor) Pseudonymize(w io.Writer, r io.Reader) error {
+	p := &pseudonymizer{c: c, words: make(map[string]string), used: make(map[string]bool)}
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for {
+		line, err := br.ReadString('\n')
+		if _, err := bw.WriteString(p.line(line)); err != nil {
+			return err
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type pseudonymizer struct {
+	c     *Constructor
+	words map[string]string // lower-case words and their pseudo-words
+	used  map[string]bool   // the pseudo-words
+}
+
+func (p *pseudonymizer) line(s string) string {
+	a := p.c.alphabet()
+	var sb strings.Builder
+	last := 0
+	for _, m := range a.words(s) {
+		beg, end := m[0], m[1]
+		// Leave alone the words having letters of other alphabets.
+		if r, _ := utf8.DecodeLastRuneInString(s[:beg]); beg > 0 && unicode.IsLetter(r) && !a.isMark(r) {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(s[end:]); end < len(s) && unicode.IsLetter(r) && !a.isMark(r) {
+			continue
+		}
+		sb.WriteString(s[last:beg])
+		sb.WriteString(p.word(s[beg:end]))
+		last = end
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// word returns the pseudo-word of word in the case of word, with the marks
+// of the alphabet, like the apostrophe in Ukrainian, in their places.
+func (p *pseudonymizer) word(word string) string {
+	a := p.c.alphabet()
+	lower := strings.ToLower(a.stripMarks(word))
+	pw, ok := p.words[lower]
+	if !ok {
+		pw = p.pseudo(lower)
+		p.words[lower] = pw
+		p.used[pw] = true
+	}
+
+	rr := []rune(pw)
+	var sb strings.Builder
+	i := 0
+	for _, r := range word {
+		if a.isMark(r) {
+			sb.WriteRune(r)
+			continue
+		}
+		if unicode.IsUpper(r) {
+			rr[i] = unicode.ToUpper(rr[i])
+		}
+		sb.WriteRune(rr[i])
+		i++
+	}
+	return sb.String()
+}
+
+// pseudo returns a new pseudo-word for a lower-case word.
+func (p *pseudonymizer) pseudo(word string) string {
+	a := p.c.alphabet()
+	w, _ := a.indexes(word)
+	word = a.makeString(w) // with 'ё' folded into 'е'
+	var mask strings.Builder
+	for _, b := range w {
+		switch {
+		case a.vowels&(1<<b) != 0:
+			mask.WriteByte('V')
+		case a.consonants&(1<<b) != 0:
+			mask.WriteByte('C')
+		default:
+			mask.WriteRune(a.letters[b])
+		}
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		pw, err := p.c.TryWordMask(mask.String())
+		if err != nil {
+			break
+		}
+		if pw != word && !p.used[pw] {
+			return pw
+		}
+	}
+
+	src := p.c.source()
+	var pw string
+	for i := 0; i < maxAttempts; i++ {
+		for i, b := range w {
+			set := a.vowels
+			switch {
+			case set&(1<<b) != 0:
+			case a.consonants&(1<<b) != 0:
+				set = a.consonants
+			default:
+				continue
+			}
+			for k := src.rand.Intn(bits.OnesCount64(set)); k > 0; k-- {
+				set &= set - 1
+			}
+			w[i] = byte(bits.TrailingZeros64(set))
+		}
+		if pw = a.makeString(w); pw != word && !p.used[pw] {
+			break
+		}
+	}
+	return pw
+}
@@ -87,31 +87,83 @@ var (
 	// ю 		3220715
 	// я 		10139085
 
-	randA = vose.New(
-		_rand,
-		[]float64{
-			40487008, 8051767, 22930719, 8564640, 15052118, 42691213 + 184928,
-			4746916, 8329904, 37153142, 6106262, 17653469, 22230174, 16203060,
-			33838881, 55414481, 14201572, 23916825, 27627040, 31620970, 13245712,
-			1335747, 4904176, 2438807, 7300193, 3678738, 1822476, 185452, 9595941,
-			8784613, 1610107, 3220715, 10139085,
-		},
-	)
-
-	randV = vose.New(
-		_rand,
-		[]float64{
-			40487008, 42691213 + 184928, 37153142, 55414481, 13245712, 9595941,
-			1610107, 3220715, 10139085,
-		},
-	)
-
-	randC = vose.New(
-		_rand,
-		[]float64{
-			8051767, 22930719, 8564640, 15052118, 4746916, 8329904, 6106262, 17653469,
-			22230174, 16203060, 33838881, 14201572, 23916825, 27627040, 31620970,
-			1335747, 4904176, 2438807, 7300193, 3678738, 1822476,
-		},
-	)
+	letterFreq = []float64{
+		40487008, 8051767, 22930719, 8564640, 15052118, 42691213 + 184928,
+		4746916, 8329904, 37153142, 6106262, 17653469, 22230174, 16203060,
+		33838881, 55414481, 14201572, 23916825, 27627040, 31620970, 13245712,
+		1335747, 4904176, 2438807, 7300193, 3678738, 1822476, 185452, 9595941,
+		8784613, 1610107, 3220715, 10139085,
+	}
+
+	yoLetterFreq = []float64{
+		40487008, 8051767, 22930719, 8564640, 15052118, 42691213, 184928,
+		4746916, 8329904, 37153142, 6106262, 17653469, 22230174, 16203060,
+		33838881, 55414481, 14201572, 23916825, 27627040, 31620970, 13245712,
+		1335747, 4904176, 2438807, 7300193, 3678738, 1822476, 185452, 9595941,
+		8784613, 1610107, 3220715, 10139085,
+	}
+
+	// https://en.wikipedia.org/wiki/Letter_frequency, in thousandths of
+	// a percent.
+	latinLetterFreq = []float64{
+		8167, 1492, 2782, 4253, 12702, 2228, 2015, 6094, 6966, 153, 772, 4025,
+		2406, 6749, 7507, 1929, 95, 5987, 6327, 9056, 2758, 978, 2360, 150,
+		1974, 74,
+	}
+
+	vowelFreq = []float64{
+		40487008, 42691213 + 184928, 37153142, 55414481, 13245712, 9595941,
+		1610107, 3220715, 10139085,
+	}
+
+	consonantFreq = []float64{
+		8051767, 22930719, 8564640, 15052118, 4746916, 8329904, 6106262, 17653469,
+		22230174, 16203060, 33838881, 14201572, 23916825, 27627040, 31620970,
+		1335747, 4904176, 2438807, 7300193, 3678738, 1822476,
+	}
+
+	defaultSource = newSource(_rand)
 )
+
+// source draws letters from the frequency tables above using its own
+// pseudo-random number generator.
+type source struct {
+	rand  *rand.Rand
+	randA *vose.Vose
+	randV *vose.Vose
+	randC *vose.Vose
+}
+
+func newSource(r *rand.Rand) *source {
+	return &source{
+		rand:  r,
+		randA: vose.New(r, letterFreq),
+		randV: vose.New(r, vowelFreq),
+		randC: vose.New(r, consonantFreq),
+	}
+}
+
+// Seed makes c use its own pseudo-random number generator initialized with
+// seed. Constructors with the same tables and the same seed produce the same
+// sequence of words.
+func (c *Constructor) Seed(seed int64) {
+	c.src = newSource(rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)}))
+}
+
+// SetSource makes c draw random numbers from src instead of the package-wide
+// generator. A nil src restores the default. Unlike Seed, SetSource does not
+// guard src with a mutex, so c is safe for concurrent use only if src is.
+func (c *Constructor) SetSource(src rand.Source) {
+	if src == nil {
+		c.src = nil
+		return
+	}
+	c.src = newSource(rand.New(src))
+}
+
+func (c *Constructor) source() *source {
+	if c.src != nil {
+		return c.src
+	}
+	return defaultSource
+}
@@ -22,8 +22,51 @@ import (
 	"os"
 )
 
-// LoadFrom loads binary representation of Constructor from r.
+// The binary representation of Constructor consists of its tables,
+// followed by extensionSignature and sections holding what the tables
+// cannot: the alphabet if it is not Russian, the tables of a model
+// replacing them, the lengths of the learned words, the way the constructor
+// learned them, and the remembered words. Files written by earlier versions
+// of this package have the tables alone.
+// Each section starts with a four-byte tag and the length of its payload.
+// Readers skip the sections they do not know about, and readers that know
+// nothing about sections read the tables alone.
+const extensionSignature = "RWCX"
+
+const (
+	tagAlphabet = "ALPH"
+	tagModel    = "MODL"
+	tagLengths  = "LENS"
+	tagKnown    = "KNWN"
+	tagLearning = "LERN"
+)
+
+// LoadFrom loads binary representation of Constructor from r. If c has an
+// alphabet other than Russian and r holds the tables of a Russian
+// constructor, such as one written by older versions of this package, the
+// Russian letters are mapped to the letters of the alphabet, which must
+// have all of them, unless the alphabet maps them otherwise (see
+// Alphabet.MapSlots).
 func (c *Constructor) LoadFrom(r io.Reader) error {
+	a, order, suffixLen := c.alphabet(), c.order(), c.suffixLen()
+	if err := c.loadTables(r); err != nil {
+		return err
+	}
+	if err := c.loadSections(r); err != nil {
+		return err
+	}
+	return c.fitTables(a, order, suffixLen)
+}
+
+func (c *Constructor) loadTables(r io.Reader) error {
+	c.rev++
+	c.ownCache()
+	c.abc = nil
+	c.m = nil
+	c.freq = nil
+	c.lengths = [maxLength]uint32{}
+	c.known = nil
+	c.learning = LegacyLearning
 	if err := binary.Read(r, binary.LittleEndian, c.ng4[:]); err != nil {
 		return err
 	}
@@ -45,12 +88,140 @@ func (c *Constructor) LoadFrom(r io.Reader) error {
 	return nil
 }
 
+func (c *Constructor) loadSections(r io.Reader) error {
+	var sig [len(extensionSignature)]byte
+	if _, err := io.ReadFull(r, sig[:]); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if string(sig[:]) != extensionSignature {
+		return errors.New("invalid extension signature")
+	}
+
+	for {
+		var header struct {
+			Tag  [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		data := make([]byte, header.Size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := c.loadSection(string(header.Tag[:]), data); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Constructor) loadSection(tag string, data []byte) error {
+	r := bytes.NewReader(data)
+	switch tag {
+	case tagAlphabet:
+		a, err := unmarshalAlphabet(data)
+		if err != nil {
+			return err
+		}
+		c.abc = a
+	case tagModel:
+		m := newModel(c.alphabet(), minOrder, 0)
+		if err := m.unmarshal(data); err != nil {
+			return err
+		}
+		c.m = m
+	case tagLengths:
+		return binary.Read(r, binary.LittleEndian, c.lengths[:])
+	case tagLearning:
+		if len(data) != 1 || Learning(data[0]) > LegacyLearning {
+			return errors.New("invalid learning section")
+		}
+		c.learning = Learning(data[0])
+	case tagKnown:
+		var k uint32
+		if err := binary.Read(r, binary.LittleEndian, &k); err != nil {
+			return err
+		}
+		bits := make([]uint64, r.Len()/8)
+		if len(bits) == 0 || k == 0 {
+			return errors.New("invalid known words section")
+		}
+		if err := binary.Read(r, binary.LittleEndian, bits); err != nil {
+			return err
+		}
+		c.known = &bloom{k: k, bits: bits}
+	}
+	return nil
+}
+
+type section struct {
+	tag  string
+	data []byte
+}
+
+// sections returns the sections to be written after the tables.
+func (c *Constructor) sections() []section {
+	var ss []section
+	if a := c.alphabet(); a != Russian {
+		ss = append(ss, section{tagAlphabet, a.marshal()})
+	}
+	if c.m != nil {
+		ss = append(ss, section{tagModel, c.m.marshal()})
+	}
+	if c.lengths != [maxLength]uint32{} {
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, c.lengths[:])
+		ss = append(ss, section{tagLengths, buf.Bytes()})
+	}
+	ss = append(ss, section{tagLearning, []byte{byte(c.learning)}})
+	if c.known != nil {
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, c.known.k)
+		binary.Write(buf, binary.LittleEndian, c.known.bits)
+		ss = append(ss, section{tagKnown, buf.Bytes()})
+	}
+	return ss
+}
+
 // WriteTo writes binary representation of Constructor to w.
 func (c *Constructor) WriteTo(w io.Writer) (int64, error) {
-	if err := binary.Write(w, binary.LittleEndian, c); err != nil {
-		return 0, err
+	var n int64
+	for _, data := range []interface{}{c.ng4[:], c.ng3[:], c.ng3beg[:], c.ng3end[:], c.ng2[:], c.ng1} {
+		if err := binary.Write(w, binary.LittleEndian, data); err != nil {
+			return n, err
+		}
+		n += int64(binary.Size(data))
 	}
-	return int64(len(c.ng4) + len(c.ng3) + len(c.ng3beg) + len(c.ng3end) + len(c.ng2) + 4), nil
+
+	sections := c.sections()
+	if len(sections) == 0 {
+		return n, nil
+	}
+	m, err := io.WriteString(w, extensionSignature)
+	n += int64(m)
+	if err != nil {
+		return n, err
+	}
+	for _, s := range sections {
+		header := make([]byte, 8, 8+len(s.data))
+		copy(header, s.tag)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(s.data)))
+		m, err := w.Write(append(header, s.data...))
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
 }
 
 // LoadFromRWC loads binary representation of Constructor from an .RWC file.
@@ -100,5 +271,40 @@ func (c *Constructor) LoadFromRWC(filename string) error {
 		}
 	}
 
-	return c.LoadFrom(bytes.NewReader(buf))
+	a, order, suffixLen := c.alphabet(), c.order(), c.suffixLen()
+	if err := c.loadTables(bytes.NewReader(buf)); err != nil {
+		return err
+	}
+	return c.fitTables(a, order, suffixLen)
+}
+
+// fitTables gives c alphabet a, the order and the suffix length if c has
+// just loaded the tables alone, mapping their slots to the letters of a
+// (see MapSlots) and moving them into a model unless c needs none. c keeps
+// the alphabet and the model it has loaded. The tables of the positions at
+// the end of words, which the loaded tables lack, start as copies of the
+// 4-gram table.
+func (c *Constructor) fitTables(a *Alphabet, order, suffixLen int) error {
+	if c.abc != nil || c.m != nil || a == Russian && order == minOrder && suffixLen == 0 {
+		return nil
+	}
+	slots, err := a.slotIndexes()
+	if err != nil {
+		return err
+	}
+	c.abc = a
+	c.m = newModel(a, order, suffixLen)
+	c.m.importTables(c, slots)
+	for _, t := range c.m.ends {
+		for k, s := range c.m.tables[tabNg4] {
+			t[k] = s
+		}
+	}
+	c.ng4 = [32768]uint32{}
+	c.ng3 = [1024]uint32{}
+	c.ng3beg = [1024]uint32{}
+	c.ng3end = [1024]uint32{}
+	c.ng2 = [32]uint32{}
+	c.ng1 = 0
+	return nil
 }
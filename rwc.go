@@ -11,17 +11,61 @@
 // You should have received a copy of the GNU General Public License along
 // with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-// Package rwc provides a pseudo-Russian word constructor.
+// Package rwc provides a pseudo-Russian word constructor. Constructors
+// with other alphabets (see NewConstructor) construct pseudo-words of other
+// languages the same way.
 package rwc
 
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrInvalidMask is reported, wrapped in a *MaskError, for a mask
+	// containing a character that is neither a letter nor a special symbol.
+	ErrInvalidMask = errors.New("invalid character in mask")
+
+	// ErrNoMatch is returned when the constructor cannot produce any word
+	// of the requested length or matching the requested mask.
+	ErrNoMatch = errors.New("no matching word")
+)
+
+// MaskError describes an invalid character in a mask.
+type MaskError struct {
+	Rune   rune // the offending character
+	Offset int  // its byte offset in the mask
+}
+
+func (e *MaskError) Error() string {
+	return fmt.Sprintf("invalid character %q in mask at offset %d", e.Rune, e.Offset)
+}
+
+// Is reports whether target is ErrInvalidMask.
+func (e *MaskError) Is(target error) bool {
+	return target == ErrInvalidMask
+}
+
 var (
 	vowels     = []rune("аеиоуыэюя")
 	consonants = []rune("бвгджзйклмнпрстфхцчшщ")
-	incv       = [32]byte{5, 0, 0, 0, 0, 8, 0, 0, 14, 0, 0, 0, 0, 0, 19, 0, 0, 0, 0, 27, 0, 0, 0, 0, 0, 0, 0, 29, 0, 30, 31, 0}
-	incc       = [32]byte{0, 2, 3, 4, 6, 0, 7, 9, 0, 10, 11, 12, 13, 15, 0, 16, 17, 18, 20, 0, 21, 22, 23, 24, 25, 1, 0, 0, 0, 0, 0, 0}
 )
 
-// Constructor is a pseudo-Russian word constructor.
+// The special symbols of a mask in its original form, as returned by
+// simpleMask. Letters are kept as their indexes, which are smaller.
+const (
+	maskAny       = 0x80 | '.'
+	maskVowel     = 0x80 | 'V'
+	maskConsonant = 0x80 | 'C'
+)
+
+// Constructor is a pseudo-Russian word constructor. Several goroutines may
+// construct words with a Constructor at once, but not while it learns from
+// a text or loads tables; use SafeConstructor for that.
 type Constructor struct {
 	ng4    [32768]uint32
 	ng3    [1024]uint32
@@ -29,6 +73,90 @@ type Constructor struct {
 	ng3end [1024]uint32
 	ng2    [32]uint32
 	ng1    uint32
+
+	src  *source
+	freq *frequencies
+	rev  uint64 // incremented whenever the tables change
+
+	sampling Sampling
+	lattices *latticeCache
+
+	lengths       [maxLength]uint32
+	lengthWeights []float64
+
+	known        *bloom
+	excludeKnown bool
+
+	abc      *Alphabet // nil means Russian
+	m        *model    // replaces the tables if the alphabet does not fit them
+	learning Learning
+	secret   []byte // the key of WordFor and WordMaskFor
+}
+
+// Options configure a Constructor created by NewConstructor.
+type Options struct {
+	// Alphabet is the alphabet of the words. The default is Russian.
+	Alphabet *Alphabet
+
+	// Order is the length of the longest n-grams in the middle of words,
+	// from 4 (the default) to 8. A constructor of a higher order looks
+	// further back to pick the next letter and makes more natural words,
+	// provided it learns from a text large enough; where it has not seen
+	// the letters it looks at, it backs off to fewer of them, down to
+	// three. Constructors loaded from .RWC files only have 4-grams.
+	Order int
+
+	// SuffixLen is the number of positions at the end of words, at most
+	// 8, that have tables of their own: a letter at one of them must
+	// have been seen after the same three letters at the same distance
+	// from the end of a word. This makes the words end like the words of
+	// the text, with convincing grammatical suffixes. The default is 0.
+	SuffixLen int
+
+	// Learning selects which 4-grams of a word the constructor learns.
+	// The default is AllNGrams.
+	Learning Learning
+}
+
+// NewConstructor returns an empty constructor configured by opts. It has to
+// learn from a text or be loaded from a file before it can construct words.
+// The zero Constructor is the same as the one returned by
+// NewConstructor(Options{}). NewConstructor panics if the order or the
+// suffix length is out of range.
+func NewConstructor(opts Options) *Constructor {
+	order := opts.Order
+	if order == 0 {
+		order = minOrder
+	}
+	if order < minOrder || order > maxOrder {
+		panic("rwc: invalid order " + strconv.Itoa(order))
+	}
+	if opts.SuffixLen < 0 || opts.SuffixLen > maxSuffixLen {
+		panic("rwc: invalid suffix length " + strconv.Itoa(opts.SuffixLen))
+	}
+	c := &Constructor{abc: opts.Alphabet, learning: opts.Learning}
+	c.ownCache()
+	if !c.alphabet().classic() || order > minOrder || opts.SuffixLen > 0 {
+		c.m = newModel(c.alphabet(), order, opts.SuffixLen)
+	}
+	return c
+}
+
+// order returns the length of the longest n-grams of c.
+func (c *Constructor) order() int {
+	if c.m == nil {
+		return minOrder
+	}
+	return c.m.order()
+}
+
+// suffixLen returns the number of positions at the end of words having
+// tables of their own.
+func (c *Constructor) suffixLen() int {
+	if c.m == nil {
+		return 0
+	}
+	return len(c.m.ends)
 }
 
 // Word returns a pseudo-Russian word of the specified length.
@@ -37,161 +165,347 @@ func Word(length int) string {
 }
 
 // WordMask returns a pseudo-Russian word matching the mask.
-// The mask may contain lowercase letters from 'а' to 'я' (excluding 'ё')
-// and the following special symbols:
+// The mask may contain letters of the alphabet of the constructor, which
+// for the default Russian alphabet treats 'ё' as 'е', and the following
+// special symbols:
 // V - for a vowel;
 // C - for a consonant;
-// . (dot) - for any letter.
+// . (dot) - for any letter;
+// [бвг] - for any of the listed letters, [^ьъ] - for any letter but those,
+// [а-е] - for a range of letters;
+// (ый|ий|ой) - for any of the alternatives, each of them a mask itself;
+// ? - after a letter, class or group, to make it optional;
+// {m} or {m,n} - after a letter, class or group, to repeat it m times or
+// from m to n times.
 func WordMask(mask string) string {
 	return DefaultConstructor.WordMask(mask)
 }
 
+// AppendWord appends a pseudo-Russian word of the specified length to dst.
+// See Constructor.AppendWord.
+func AppendWord(dst []byte, length int) []byte {
+	return DefaultConstructor.AppendWord(dst, length)
+}
+
+// AppendWordMask appends a pseudo-Russian word matching the mask to dst.
+// See Constructor.AppendWordMask.
+func AppendWordMask(dst []byte, mask string) []byte {
+	return DefaultConstructor.AppendWordMask(dst, mask)
+}
+
+// Words returns count pseudo-Russian words of the specified length.
+func Words(length, count int) []string {
+	return DefaultConstructor.Words(length, count)
+}
+
+// TryWord is like Word but returns ErrNoMatch instead of an empty string
+// if there is no word of the specified length.
+func TryWord(length int) (string, error) {
+	return DefaultConstructor.TryWord(length)
+}
+
+// TryWordMask is like WordMask but returns an error instead of panicking
+// on an invalid mask and ErrNoMatch instead of an empty string if no word
+// matches the mask.
+func TryWordMask(mask string) (string, error) {
+	return DefaultConstructor.TryWordMask(mask)
+}
+
 // Word returns a pseudo-Russian word of the specified length.
 func (c *Constructor) Word(n int) string {
+	w, _ := c.TryWord(n)
+	return w
+}
+
+// TryWord is like Word but returns ErrNoMatch instead of an empty string
+// if there is no word of the specified length. A non-positive length yields
+// an empty word and no error.
+func (c *Constructor) TryWord(n int) (string, error) {
 	if n <= 0 {
-		return ""
+		return "", nil
+	}
+	w := c.freshWord(n, nil)
+	if w == nil {
+		return "", ErrNoMatch
 	}
+	return c.makeString(w), nil
+}
 
-	w := make([]byte, n)
-	for i := 0; i < len(w); i++ {
-		w[i] = byte(randA.Rand())
+// scratchLen is the length of the longest word that AppendWord and the
+// like construct without allocating memory.
+const scratchLen = 32
+
+// AppendWord is like Word but appends the word to dst, encoded in UTF-8,
+// and returns the extended buffer. It appends nothing if there is no word
+// of the specified length. A constructor of Russian words loaded from an
+// .RWC file constructs words of up to 32 letters without allocating
+// memory.
+func (c *Constructor) AppendWord(dst []byte, n int) []byte {
+	if n <= 0 {
+		return dst
 	}
-	orig := make([]byte, n)
-	copy(orig, w)
+	var scratch [scratchLen]byte
+	w := c.freshWord(n, scratch[:])
+	return c.alphabet().appendLetters(dst, w)
+}
 
-	for i := 0; i < n; {
-		if c.check(w, i) {
-			i++
-			continue
+// Words returns count pseudo-Russian words of the specified length, or nil
+// if there is no such word. The words share the memory allocated for them
+// at once.
+func (c *Constructor) Words(n, count int) []string {
+	if count <= 0 {
+		return nil
+	}
+	words := make([]string, count)
+	if n <= 0 {
+		return words
+	}
+	var scratch [scratchLen]byte
+	var sb strings.Builder
+	sb.Grow(count * n * utf8.RuneLen(c.alphabet().letters[0]))
+	ends := make([]int, count)
+	for i := range ends {
+		w := c.freshWord(n, scratch[:])
+		if w == nil {
+			return nil
 		}
+		for _, b := range w {
+			sb.WriteRune(c.alphabet().letters[b])
+		}
+		ends[i] = sb.Len()
+	}
+	s, beg := sb.String(), 0
+	for i, end := range ends {
+		words[i] = s[beg:end]
+		beg = end
+	}
+	return words
+}
 
-		w[i] = (w[i] + 1) % 32
-		for i >= 0 && w[i] == orig[i] {
+// word returns a word of n letters or nil. It may use scratch for the word
+// if it has room for n letters.
+func (c *Constructor) word(n int, scratch []byte) []byte {
+	src := c.source()
+	if c.sampling == Uniform {
+		return c.uniform(src, lengthPattern(c.alphabet(), n))
+	}
+	if !c.legacy() {
+		return c.sample(src, lengthPattern(c.alphabet(), n))
+	}
+	if cap(scratch) < n {
+		scratch = make([]byte, n)
+	}
+	return c.walk(src, scratch[:n], nil)
+}
+
+// walk fills w with letters the tables allow, matching the simple mask
+// bmask unless it is nil, and returns w, or nil if there is no such word.
+// At every position it picks one of the letters allowed there that it has
+// not tried yet, in proportion to their frequency in Russian, and goes back
+// to the previous position when none is left.
+func (c *Constructor) walk(src *source, w, bmask []byte) []byte {
+	n := len(w)
+	var buf [scratchLen]uint64
+	left := buf[:]
+	if n > len(buf) {
+		left = make([]uint64, n)
+	}
+	how := func(i int) byte {
+		if bmask == nil {
+			return maskAny
+		}
+		return bmask[i]
+	}
+
+	left[0] = c.next(w, 0) & Russian.maskSet(how(0))
+	for i := 0; ; {
+		if left[i] == 0 {
 			if i == 0 {
-				return ""
+				return nil
 			}
 			i--
-			w[i] = (w[i] + 1) % 32
+			continue
 		}
+		b := c.pickLetter(src, left[i], how(i))
+		left[i] &^= 1 << b
+		w[i] = b
+		i++
+		if i == n {
+			return w
+		}
+		left[i] = c.next(w, i) & Russian.maskSet(how(i))
+	}
+}
+
+// pickLetter picks one of the letters in s, which match the mask symbol
+// how, in proportion to their frequency in Russian. It first draws a letter
+// matching how, which is all it takes if s has that letter, and otherwise
+// picks among the letters of s.
+func (c *Constructor) pickLetter(src *source, s uint64, how byte) byte {
+	var b byte
+	switch how {
+	case maskAny:
+		b = byte(src.randA.Rand())
+	case maskVowel:
+		b = byte(vowels[src.randV.Rand()] - 'а')
+	case maskConsonant:
+		b = byte(consonants[src.randC.Rand()] - 'а')
+	default:
+		return how
+	}
+	if s&(1<<b) != 0 {
+		return b
 	}
 
-	return makeString(w)
+	var total float64
+	for t := s; t != 0; t &= t - 1 {
+		total += letterFreq[bits.TrailingZeros64(t)]
+	}
+	r := src.rand.Float64() * total
+	for t := s; t != 0; t &= t - 1 {
+		b = byte(bits.TrailingZeros64(t))
+		if r < letterFreq[b] {
+			break
+		}
+		r -= letterFreq[b]
+	}
+	return b
 }
 
 // WordMask returns a pseudo-Russian word matching the mask.
-// The mask may contain lowercase letters from 'а' to 'я' (excluding 'ё')
-// and the following special symbols:
+// The mask may contain letters of the alphabet of the constructor, which
+// for the default Russian alphabet treats 'ё' as 'е', and the following
+// special symbols:
 // V - for a vowel;
 // C - for a consonant;
-// . (dot) - for any letter.
+// . (dot) - for any letter;
+// [бвг] - for any of the listed letters, [^ьъ] - for any letter but those,
+// [а-е] - for a range of letters;
+// (ый|ий|ой) - for any of the alternatives, each of them a mask itself;
+// ? - after a letter, class or group, to make it optional;
+// {m} or {m,n} - after a letter, class or group, to repeat it m times or
+// from m to n times.
 func (c *Constructor) WordMask(mask string) string {
-	if mask == "" {
+	w, err := c.TryWordMask(mask)
+	if err == ErrNoMatch {
 		return ""
 	}
-
-	var bmask []byte
-	for _, r := range mask {
-		switch {
-		case r == '.' || r == 'V' || r == 'C':
-			bmask = append(bmask, byte(r))
-		case 'а' <= r && r <= 'я':
-			bmask = append(bmask, byte(r-'а'))
-		case 'А' <= r && r <= 'Я':
-			bmask = append(bmask, byte(r-'А'))
-		case r == 'ё' || r == 'Ё':
-			bmask = append(bmask, 'е'-'а')
-		default:
-			panic("invalid character in mask")
-		}
+	if err != nil {
+		panic(err)
 	}
+	return w
+}
 
-	n := len(bmask)
-	w := make([]byte, n)
-	for i := 0; i < n; i++ {
-		switch bmask[i] {
-		case '.':
-			w[i] = byte(randA.Rand())
-		case 'V':
-			w[i] = byte(vowels[randV.Rand()] - 'а')
-		case 'C':
-			w[i] = byte(consonants[randC.Rand()] - 'а')
-		default:
-			w[i] = bmask[i]
-		}
+// TryWordMask is like WordMask but returns a *MaskError instead of
+// panicking on an invalid mask and ErrNoMatch instead of an empty string if
+// no word matches the mask. An empty mask yields an empty word and no error.
+func (c *Constructor) TryWordMask(mask string) (string, error) {
+	if mask == "" {
+		return "", nil
 	}
-	orig := make([]byte, n)
-	copy(orig, w)
+	w, err := c.tryWordMask(mask, nil)
+	if err != nil {
+		return "", err
+	}
+	return c.makeString(w), nil
+}
 
-	for i := 0; i < n; {
-		if c.check(w, i) {
-			i++
-			continue
-		}
+// AppendWordMask is like WordMask but appends the word to dst, encoded in
+// UTF-8, and returns the extended buffer. It appends nothing if no word
+// matches the mask. A constructor of Russian words loaded from an .RWC
+// file constructs words matching masks of up to 32 letters, dots, V and C
+// without allocating memory.
+func (c *Constructor) AppendWordMask(dst []byte, mask string) []byte {
+	if mask == "" {
+		return dst
+	}
+	var scratch [2 * scratchLen]byte
+	w, err := c.tryWordMask(mask, scratch[:])
+	if err == ErrNoMatch {
+		return dst
+	}
+	if err != nil {
+		panic(err)
+	}
+	return c.alphabet().appendLetters(dst, w)
+}
 
-		w[i] = inc(w[i], bmask[i])
-		for i >= 0 && w[i] == orig[i] {
-			if i == 0 {
-				return ""
-			}
-			i--
-			w[i] = inc(w[i], bmask[i])
+// tryWordMask returns a word matching a non-empty mask. It may use scratch
+// for the word if it has room for twice as many letters.
+func (c *Constructor) tryWordMask(mask string, scratch []byte) ([]byte, error) {
+	n := len(scratch) / 2
+	var w []byte
+	if bmask := appendSimpleMask(scratch[:0:n], mask, Russian); bmask != nil && c.legacy() && c.sampling == Frequency {
+		w = c.freshWordMask(bmask, scratch[n:])
+	} else {
+		p, err := compileMask(mask, c.alphabet())
+		if err != nil {
+			return nil, err
 		}
+		w = c.fresh(func() []byte { return c.wordPattern(p) })
 	}
-
-	return makeString(w)
+	if w == nil {
+		return nil, ErrNoMatch
+	}
+	return w, nil
 }
 
-func inc(b, how byte) byte {
-	switch how {
-	case '.':
-		return (b + 1) % 32
-	case 'V':
-		return incv[b]
-	case 'C':
-		return incc[b]
-	default:
-		return b
+func (c *Constructor) wordPattern(p *pattern) []byte {
+	if c.sampling == Uniform {
+		return c.uniform(c.source(), p)
 	}
+	return c.sample(c.source(), p)
 }
 
-func makeString(w []byte) string {
-	rr := make([]rune, len(w))
-	for i, b := range w {
-		rr[i] = 'а' + rune(b)
+// wordMask returns a word matching a simple mask or nil. It may use
+// scratch for the word like word does.
+func (c *Constructor) wordMask(bmask, scratch []byte) []byte {
+	n := len(bmask)
+	if cap(scratch) < n {
+		scratch = make([]byte, n)
 	}
-	return string(rr)
+	return c.walk(c.source(), scratch[:n], bmask)
+}
+
+// legacy reports whether c generates words the way it always has: picking
+// letters at random according to their frequency in Russian among those the
+// tables allow (see walk). This requires the Russian alphabet and no
+// occurrence counts.
+func (c *Constructor) legacy() bool {
+	return c.m == nil && c.freq == nil && c.alphabet() == Russian
 }
 
 func (c *Constructor) check(w []byte, i int) bool {
-	good := true
+	return c.next(w, i)&(1<<w[i]) != 0
+}
+
+// next returns the set of letters allowed at position i of w given w[:i].
+func (c *Constructor) next(w []byte, i int) uint64 {
+	if c.m != nil {
+		return c.m.next(w, i)
+	}
 	n := len(w)
 	switch {
 	case n == 1:
-		good = c.ng1&(1<<w[0]) != 0
+		return uint64(c.ng1)
 	case n == 2:
 		if i == 1 {
-			index := w[0]
-			good = c.ng2[index]&(1<<w[1]) != 0
+			return uint64(c.ng2[w[0]])
 		}
 	case i < 2:
 	case i == 2:
 		index := uint16(w[0])<<5 + uint16(w[1])
 		if n == 3 {
-			good = c.ng3[index]&(1<<w[2]) != 0
-		} else {
-			good = c.ng3beg[index]&(1<<w[2]) != 0
+			return uint64(c.ng3[index])
 		}
+		return uint64(c.ng3beg[index])
 	case i == n-1:
-		index := uint16(w[n-3])<<5 + uint16(w[n-2])
-		good = c.ng3end[index]&(1<<w[n-1]) != 0
-
-		if good {
-			index := uint16(w[n-4])<<10 + uint16(w[n-3])<<5 + uint16(w[n-2])
-			good = c.ng4[index]&(1<<w[n-1]) != 0
-		}
+		index3 := uint16(w[n-3])<<5 + uint16(w[n-2])
+		index4 := uint16(w[n-4])<<10 + uint16(w[n-3])<<5 + uint16(w[n-2])
+		return uint64(c.ng3end[index3] & c.ng4[index4])
 	default:
 		index := uint16(w[i-3])<<10 + uint16(w[i-2])<<5 + uint16(w[i-1])
-		good = c.ng4[index]&(1<<w[i]) != 0
+		return uint64(c.ng4[index])
 	}
-	return good
+	return c.alphabet().all
 }
@@ -26,3 +26,47 @@ func BenchmarkWordMask(b *testing.B) {
 		WordMask(".......")
 	}
 }
+
+func BenchmarkWordLong(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Word(20)
+	}
+}
+
+func BenchmarkWordMaskLong(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		WordMask("..C.......V.........")
+	}
+}
+
+func BenchmarkMaskGenerate(b *testing.B) {
+	m := MustCompileMask(".......")
+	m.Prepare(&DefaultConstructor)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Generate(&DefaultConstructor)
+	}
+}
+
+func BenchmarkAppendWord(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = AppendWord(buf[:0], 7)
+	}
+}
+
+func BenchmarkAppendWordMask(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		buf = AppendWordMask(buf[:0], ".......")
+	}
+}
+
+func BenchmarkWords(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Words(7, 100)
+	}
+}
@@ -14,8 +14,16 @@
 package rwc
 
 import (
+	"bytes"
+	"errors"
+	"math"
+	"math/big"
+	"reflect"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -44,3 +52,1182 @@ func TestWordMask(t *testing.T) {
 		}
 	}
 }
+
+func TestSeed(t *testing.T) {
+	c1 := DefaultConstructor
+	c2 := DefaultConstructor
+	c1.Seed(42)
+	c2.Seed(42)
+	for i := 1; i <= 20; i++ {
+		w1, w2 := c1.Word(i), c2.Word(i)
+		if w1 != w2 {
+			t.Errorf("want the same words for the same seed, got %q and %q", w1, w2)
+		}
+		m1, m2 := c1.WordMask("CV...ый"), c2.WordMask("CV...ый")
+		if m1 != m2 {
+			t.Errorf("want the same words for the same seed, got %q and %q", m1, m2)
+		}
+	}
+}
+
+func TestFrequencies(t *testing.T) {
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader(strings.Repeat("аб ", 99) + "ав")); err != nil {
+		t.Fatal(err)
+	}
+	if !c.HasFrequencies() {
+		t.Fatal("want the constructor to have frequencies after LearnFrom")
+	}
+	c.Seed(1)
+	ab := 0
+	for i := 0; i < 100; i++ {
+		switch w := c.Word(2); w {
+		case "аб":
+			ab++
+		case "ав":
+		default:
+			t.Fatalf("want either %q or %q, got %q", "аб", "ав", w)
+		}
+	}
+	if ab < 80 {
+		t.Errorf("want %q to be generated most of the time, got it %d times out of 100", "аб", ab)
+	}
+
+	c.Collapse()
+	if c.HasFrequencies() {
+		t.Error("want no frequencies after Collapse")
+	}
+	if w := c.Word(2); w != "аб" && w != "ав" {
+		t.Errorf("want either %q or %q, got %q", "аб", "ав", w)
+	}
+}
+
+func TestTryWordMask(t *testing.T) {
+	_, err := TryWordMask("CVбx")
+	var merr *MaskError
+	if !errors.As(err, &merr) || !errors.Is(err, ErrInvalidMask) {
+		t.Fatalf("want a *MaskError, got %v", err)
+	}
+	if merr.Rune != 'x' || merr.Offset != 4 {
+		t.Errorf("want 'x' at offset 4, got %q at offset %d", merr.Rune, merr.Offset)
+	}
+
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("аб")); err != nil {
+		t.Fatal(err)
+	}
+	if w, err := c.TryWord(3); err != ErrNoMatch {
+		t.Errorf("want ErrNoMatch, got %q, %v", w, err)
+	}
+	if w, err := c.TryWordMask("бV"); err != ErrNoMatch {
+		t.Errorf("want ErrNoMatch, got %q, %v", w, err)
+	}
+	if w, err := c.TryWordMask("аC"); w != "аб" || err != nil {
+		t.Errorf("want %q, got %q, %v", "аб", w, err)
+	}
+}
+
+func TestEnumerateMask(t *testing.T) {
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("аг ав аб")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	collect := func(w string) bool {
+		got = append(got, w)
+		return true
+	}
+	if err := c.EnumerateMask("а.", 0, 0, collect); err != nil {
+		t.Fatal(err)
+	}
+	if want := "аб ав аг"; strings.Join(got, " ") != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	got = nil
+	if err := c.EnumerateMask("а.", 1, 1, collect); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ав"; strings.Join(got, " ") != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	rx := regexp.MustCompile("^[бвгджзйклмнпрстфхцчшщ][аеиоуыэюя][бвгджзйклмнпрстфхцчшщ]ый$")
+	prev := ""
+	if err := EnumerateMask("CVCый", 0, 100, func(w string) bool {
+		if !rx.MatchString(w) {
+			t.Errorf("want a word matching the mask, got %q", w)
+		}
+		if w <= prev {
+			t.Errorf("want words in lexicographic order, got %q after %q", w, prev)
+		}
+		prev = w
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("аг ав аб")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.CountMask("а."); err != nil || n.Int64() != 3 {
+		t.Errorf("want 3 words, got %v, %v", n, err)
+	}
+	if n := c.CountLength(3); n.Sign() != 0 {
+		t.Errorf("want no words, got %v", n)
+	}
+
+	for _, mask := range []string{"....", "CVCый", "б.л.."} {
+		want := 0
+		EnumerateMask(mask, 0, 0, func(string) bool {
+			want++
+			return true
+		})
+		got, err := CountMask(mask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Int64() != int64(want) {
+			t.Errorf("mask %q: want %d words, got %v", mask, want, got)
+		}
+	}
+	want, _ := CountMask("....")
+	if got := CountLength(4); got.Cmp(want) != 0 {
+		t.Errorf("want %v words of length 4, got %v", want, got)
+	}
+
+	// The lengths of a mask share the counts of their tails.
+	for _, mask := range []string{".{1,16}", "(C|V){2,9}V?", "ко.{0,10}"} {
+		p, err := compileMask(mask, Russian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := new(big.Int)
+		for _, n := range p.lengths() {
+			want.Add(want, DefaultConstructor.buildLattices(p, []int{n})[0].total())
+		}
+		if got, err := CountMask(mask); err != nil || got.Cmp(want) != 0 {
+			t.Errorf("mask %q: want %v words, got %v, %v", mask, want, got, err)
+		}
+	}
+	if n, err := CountMask(".{0,60}"); err != nil || n.BitLen() < 100 {
+		t.Errorf("want a huge number of words, got %v, %v", n, err)
+	}
+}
+
+func TestUniform(t *testing.T) {
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("аб ав аг вб")); err != nil {
+		t.Fatal(err)
+	}
+	c.Seed(1)
+	c.SetSampling(Uniform)
+	seen := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		seen[c.Word(2)]++
+	}
+	if len(seen) != 4 {
+		t.Fatalf("want 4 distinct words, got %v", seen)
+	}
+	for w, n := range seen {
+		if n < 800 || n > 1200 {
+			t.Errorf("want %q to be generated about 1000 times, got %d", w, n)
+		}
+	}
+
+	rx := regexp.MustCompile("^[бвгджзйклмнпрстфхцчшщ][аеиоуыэюя][а-я]{3}ый$")
+	d := DefaultConstructor
+	d.SetSampling(Uniform)
+	for i := 0; i < 20; i++ {
+		const mask = "CV...ый"
+		if w := d.WordMask(mask); !rx.MatchString(w) {
+			t.Errorf("want a word matching the mask %q, got %q", mask, w)
+		}
+	}
+}
+
+func TestWordMaskPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		mask string
+		rx   string
+	}{
+		{"[бвг]V[^ьъ]{2}", "^[бвг][аеиоуыэюя][^ьъ]{2}$"},
+		{"C.{2,4}(ый|ий|ой)", "^[бвгджзйклмнпрстфхцчшщ][а-я]{2,4}(ый|ий|ой)$"},
+		{"[к-м]а?C{3}", "^[клм]а?[бвгджзйклмнпрстфхцчшщ]{3}$"},
+		{"(CV){2}", "^([бвгджзйклмнпрстфхцчшщ][аеиоуыэюя]){2}$"},
+	} {
+		rx := regexp.MustCompile(tc.rx)
+		for i := 0; i < 20; i++ {
+			w, err := TryWordMask(tc.mask)
+			if err != nil {
+				t.Fatalf("mask %q: %v", tc.mask, err)
+			}
+			if !rx.MatchString(w) {
+				t.Errorf("want a word matching the mask %q, got %q", tc.mask, w)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		mask   string
+		offset int
+	}{
+		{"[абв", 0},
+		{"[]", 0},
+		{"а(б|в", 2},
+		{"аб)", 4},
+		{"?а", 0},
+		{"а{2,1}", 2},
+		{"а{x}", 2},
+		{"[б-а]", 4},
+		{"[^]", 0},
+		{"ко[^]", 4},
+	} {
+		var merr *MaskError
+		if _, err := TryWordMask(tc.mask); !errors.As(err, &merr) || merr.Offset != tc.offset {
+			t.Errorf("mask %q: want an error at offset %d, got %v", tc.mask, tc.offset, err)
+		}
+	}
+
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("аб ав вб абв")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := c.EnumerateMask("[аб](б|в|бв)", 0, 0, func(w string) bool {
+		got = append(got, w)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "аб ав абв"; strings.Join(got, " ") != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if n, err := c.CountMask("[аб](б|в|бв)"); err != nil || n.Int64() != 3 {
+		t.Errorf("want 3 words, got %v, %v", n, err)
+	}
+}
+
+func TestCompileMask(t *testing.T) {
+	if _, err := CompileMask("CV(ый|"); !errors.Is(err, ErrInvalidMask) {
+		t.Errorf("want ErrInvalidMask, got %v", err)
+	}
+	for _, mask := range []string{"((((а{0}){100}){100}){100}){100}", "(((((а{0}){100}){100}){100}){100}){100}"} {
+		if _, err := CompileMask(mask); err != ErrMaskTooComplex {
+			t.Errorf("mask %q: want ErrMaskTooComplex, got %v", mask, err)
+		}
+		if _, err := TryWordMask(mask); err != ErrMaskTooComplex {
+			t.Errorf("mask %q: want ErrMaskTooComplex, got %v", mask, err)
+		}
+	}
+	for _, mask := range []string{"[]", "[^]", "ко[^]{2}"} {
+		var merr *MaskError
+		if _, err := CompileMask(mask); !errors.As(err, &merr) {
+			t.Errorf("mask %q: want a *MaskError, got %v", mask, err)
+		}
+	}
+
+	m := MustCompileMask("CV.{1,3}(ый|ий)")
+	rx := regexp.MustCompile("^[бвгджзйклмнпрстфхцчшщ][аеиоуыэюя][а-я]{1,3}(ый|ий)$")
+	if err := m.Prepare(&DefaultConstructor); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := CountMask(m.String())
+	if got := m.Count(&DefaultConstructor); got.Cmp(want) != 0 {
+		t.Errorf("want %v words, got %v", want, got)
+	}
+	for i := 0; i < 20; i++ {
+		w, err := m.Generate(&DefaultConstructor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rx.MatchString(w) {
+			t.Errorf("want a word matching the mask %q, got %q", m, w)
+		}
+	}
+
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("аб ав")); err != nil {
+		t.Fatal(err)
+	}
+	if err := MustCompileMask("бV").Prepare(&c); err != ErrNoMatch {
+		t.Errorf("want ErrNoMatch, got %v", err)
+	}
+}
+
+func TestRandomWord(t *testing.T) {
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("мама мыла раму мама")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint32{0, 0, 0, 0, 4}; !reflect.DeepEqual(c.Lengths(), want) {
+		t.Errorf("want lengths %v, got %v", want, c.Lengths())
+	}
+	for i := 0; i < 20; i++ {
+		if w := c.RandomWord(); utf8.RuneCountInString(w) != 4 {
+			t.Errorf("want a word of length 4, got %q", w)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		w := WordBetween(3, 6)
+		if n := utf8.RuneCountInString(w); n < 3 || n > 6 {
+			t.Errorf("want a word of length from 3 to 6, got %q", w)
+		}
+	}
+
+	d := DefaultConstructor
+	d.SetLengths([]float64{8: 1})
+	for i := 0; i < 20; i++ {
+		if w := d.RandomWord(); utf8.RuneCountInString(w) != 8 {
+			t.Errorf("want a word of length 8, got %q", w)
+		}
+	}
+
+	var e Constructor
+	if err := e.LearnFrom(strings.NewReader("мама")); err != nil {
+		t.Fatal(err)
+	}
+	e.SetLengths([]float64{3: .1, 4: .2, 5: .7})
+	if w := e.WordBetween(3, 5); w != "мама" {
+		t.Errorf("want %q, got %q", "мама", w)
+	}
+	e.SetLengths([]float64{3: .1, 5: .2, 6: .7})
+	if w := e.WordBetween(3, 6); w != "" {
+		t.Errorf("want an empty string, got %q", w)
+	}
+}
+
+func TestKnownWords(t *testing.T) {
+	var c Constructor
+	c.RememberWords(100, 0.001)
+	if err := c.LearnFrom(strings.NewReader("Мама мамонт, динамо")); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"мама", "Мамонт", "динамо"} {
+		if !c.IsKnown(w) {
+			t.Errorf("want %q to be known", w)
+		}
+	}
+	for _, w := range []string{"мамо", "мамонтёнок", "mama"} {
+		if c.IsKnown(w) {
+			t.Errorf("want %q to be unknown", w)
+		}
+	}
+
+	c.ExcludeKnown(true)
+	for i := 0; i < 20; i++ {
+		if w, err := c.TryWord(4); w != "мамо" || err != nil {
+			t.Errorf("want %q, got %q, %v", "мамо", w, err)
+		}
+	}
+	if w, err := c.TryWordMask("ма.а"); err != ErrNoMatch {
+		t.Errorf("want ErrNoMatch, got %q, %v", w, err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var d Constructor
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !d.IsKnown("мама") || d.IsKnown("мамо") {
+		t.Error("want the remembered words to survive WriteTo and LoadFrom")
+	}
+	if !reflect.DeepEqual(d.Lengths(), c.Lengths()) {
+		t.Errorf("want lengths %v, got %v", c.Lengths(), d.Lengths())
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	var c Constructor
+	if err := c.LearnFrom(strings.NewReader("мама мыла раму")); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"мама", "Мыла", "раму"} {
+		if !c.Accepts(w) {
+			t.Errorf("want %q to be accepted, got %v", w, c.Diagnose(w))
+		}
+	}
+	for _, tc := range []struct {
+		word string
+		want Rejection
+	}{
+		{"", Rejection{}},
+		{"маma", Rejection{2, "", "m"}},
+		{"м", Rejection{0, "ng1", "м"}},
+		{"мыло", Rejection{3, "ng3end", "ыло"}},
+		{"мало", Rejection{2, "ng3beg", "мал"}},
+		{"мамла", Rejection{3, "ng4", "мамл"}},
+	} {
+		err := c.Diagnose(tc.word)
+		r, ok := err.(*Rejection)
+		if !ok || *r != tc.want {
+			t.Errorf("%q: want %+v, got %v", tc.word, tc.want, err)
+		}
+	}
+}
+
+func TestRussianYo(t *testing.T) {
+	c := NewConstructor(Options{Alphabet: RussianYo})
+	if err := c.LearnFrom(strings.NewReader("Ёлка ёжик елка")); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{"ёлка", "Елка", "ёжик"} {
+		if !c.Accepts(w) {
+			t.Errorf("want %q to be accepted, got %v", w, c.Diagnose(w))
+		}
+	}
+	if c.Accepts("ежик") {
+		t.Error("want ё and е to be different letters")
+	}
+	if w, err := c.TryWordMask("ё..."); err != nil || !strings.HasPrefix(w, "ё") {
+		t.Errorf("want a word starting with ё, got %q, %v", w, err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var d Constructor
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Accepts("ёлка") || d.Accepts("ежик") {
+		t.Error("want the alphabet and the tables to survive WriteTo and LoadFrom")
+	}
+
+	var r Constructor
+	if err := r.LoadFromRWC("vocab/MAIN.RWC"); err != nil {
+		t.Fatal(err)
+	}
+	c = NewConstructor(Options{Alphabet: RussianYo})
+	if err := c.LoadFromRWC("vocab/MAIN.RWC"); err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n <= 6; n++ {
+		if want, got := r.CountLength(n), c.CountLength(n); want.Cmp(got) != 0 {
+			t.Errorf("length %d: want %v words, got %v", n, want, got)
+		}
+	}
+}
+
+func TestAlphabets(t *testing.T) {
+	for _, tc := range []struct {
+		abc   *Alphabet
+		text  string
+		words []string
+		mask  string
+	}{
+		{Ukrainian, "Їжак ґанок, м'ясо", []string{"їжак", "Ґанок"}, "ї..."},
+		{Belarusian, "Ўсё, воўк", []string{"ўсё", "воўк"}, "..ў."},
+		{Latin, "Wolf, bird! Éclair", []string{"wolf", "Bird"}, "b.r."},
+	} {
+		c := NewConstructor(Options{Alphabet: tc.abc})
+		if err := c.LearnFrom(strings.NewReader(tc.text)); err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tc.words {
+			if !c.Accepts(w) {
+				t.Errorf("want %q to be accepted, got %v", w, c.Diagnose(w))
+			}
+		}
+		w, err := c.TryWordMask(tc.mask)
+		if err != nil || !c.Accepts(w) {
+			t.Errorf("mask %q: want an accepted word, got %q, %v", tc.mask, w, err)
+		}
+		if _, err := c.TryWordMask("ы"); tc.abc != Belarusian && !errors.Is(err, ErrInvalidMask) {
+			t.Errorf("want ErrInvalidMask, got %v", err)
+		}
+	}
+
+	// The apostrophe joins the parts of a word.
+	c := NewConstructor(Options{Alphabet: Ukrainian})
+	if err := c.LearnFrom(strings.NewReader("М'ясо, п\u2019ять і сім\u02bcя, 'так'")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint32{0, 0, 0, 1, 3}; !reflect.DeepEqual(c.Lengths(), want) {
+		t.Errorf("want lengths %v, got %v", want, c.Lengths())
+	}
+	for _, w := range []string{"мясо", "пять", "сімя", "так"} {
+		if !c.Accepts(w) {
+			t.Errorf("want %q to be accepted, got %v", w, c.Diagnose(w))
+		}
+	}
+	for _, w := range []string{"ясо", "ять"} {
+		if c.Accepts(w) {
+			t.Errorf("want %q to be rejected", w)
+		}
+	}
+	var sb strings.Builder
+	if err := c.Pseudonymize(&sb, strings.NewReader("М'ясо")); err != nil {
+		t.Fatal(err)
+	}
+	if rr := []rune(sb.String()); len(rr) != 5 || rr[1] != '\'' || !unicode.IsUpper(rr[0]) {
+		t.Errorf("want the apostrophe kept in place, got %q", sb.String())
+	}
+
+	for _, letters := range []string{"", "abcb", "aBc", "ab1"} {
+		if _, err := NewAlphabet(letters, "", ""); err == nil {
+			t.Errorf("%q: want an error", letters)
+		}
+	}
+	if _, err := NewAlphabet("abc", "x", ""); err == nil {
+		t.Error("want an error for a vowel missing from the alphabet")
+	}
+
+	letters := "abcdefghijklmnopqrstuvwxyzαβγδεζηθικλμνξοπρστυφχψωабвгдежзийклм"
+	a, err := NewAlphabet(letters, "aeiouαεηιουωаеи", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = NewConstructor(Options{Alphabet: a})
+	if err := c.LearnFrom(strings.NewReader("abωм мωba")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var d Constructor
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.alphabet().Letters(); got != letters {
+		t.Errorf("want letters %q, got %q", letters, got)
+	}
+	if w := d.WordMask("Cω.."); w != "мωba" {
+		t.Errorf("want %q, got %q", "мωba", w)
+	}
+}
+
+func TestEnglishRWC(t *testing.T) {
+	c := NewConstructor(Options{Alphabet: Latin})
+	if err := c.LoadFromRWC("vocab/ENGLISH.RWC"); err != nil {
+		t.Fatal(err)
+	}
+	c.Seed(1)
+	latin := regexp.MustCompile(`^[a-z]+$`)
+	for i := 0; i < 20; i++ {
+		if w := c.Word(6); !latin.MatchString(w) || !c.Accepts(w) {
+			t.Errorf("want an accepted Latin word, got %q", w)
+		}
+	}
+	if !c.Accepts("luking") {
+		t.Errorf("want %q to be accepted, got %v", "luking", c.Diagnose("luking"))
+	}
+	if w, err := c.TryWordMask("l...ng"); err != nil || !latin.MatchString(w) {
+		t.Errorf("want a Latin word, got %q, %v", w, err)
+	}
+
+	var r Constructor
+	if err := r.LearnFrom(strings.NewReader("абвг")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Latin.MapSlots("abcdefghijklmnopqrstuvwxyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = NewConstructor(Options{Alphabet: a})
+	if err := c.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if w := c.Word(4); w != "abcd" {
+		t.Errorf("want %q, got %q", "abcd", w)
+	}
+	if _, err := Latin.MapSlots("абв"); err == nil {
+		t.Error("want an error for a slot holding a letter missing from the alphabet")
+	}
+}
+
+func TestOrder(t *testing.T) {
+	const text = "колокол колобок поколение молоко"
+	c4 := NewConstructor(Options{Alphabet: RussianYo})
+	c5 := NewConstructor(Options{Alphabet: RussianYo, Order: 5})
+	for _, c := range []*Constructor{c4, c5} {
+		if err := c.LearnFrom(strings.NewReader(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c4.Accepts("молобок") {
+		t.Errorf("want %q to be accepted by 4-grams, got %v", "молобок", c4.Diagnose("молобок"))
+	}
+	want := Rejection{4, "ng5", "молоб"}
+	if r, ok := c5.Diagnose("молобок").(*Rejection); !ok || *r != want {
+		t.Errorf("want %+v, got %v", want, c5.Diagnose("молобок"))
+	}
+	// "локо" is only seen at the end of a word, so the letter after it is
+	// allowed by the shorter context "око" of "окорок".
+	c5 = NewConstructor(Options{Alphabet: RussianYo, Order: 5})
+	if err := c5.LearnFrom(strings.NewReader("молоко окорок")); err != nil {
+		t.Fatal(err)
+	}
+	if !c5.Accepts("молокорок") {
+		t.Errorf("want %q to be accepted after backing off, got %v", "молокорок", c5.Diagnose("молокорок"))
+	}
+
+	c := NewConstructor(Options{Order: 6})
+	if err := c.LearnFrom(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var d Constructor
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.order() != 6 {
+		t.Errorf("want order 6, got %d", d.order())
+	}
+	for n := 6; n <= 9; n++ {
+		if want, got := c.CountLength(n), d.CountLength(n); want.Cmp(got) != 0 {
+			t.Errorf("length %d: want %v words, got %v", n, want, got)
+		}
+	}
+}
+
+func TestSuffixLen(t *testing.T) {
+	c := NewConstructor(Options{SuffixLen: 4})
+	if err := c.LearnFrom(strings.NewReader("мостовая радость")); err != nil {
+		t.Fatal(err)
+	}
+	// "мост" is a 4-gram of "мостовая", but 'т' has been seen as the
+	// letter before the last one only after "дос".
+	want := &Rejection{3, "end2", "мост"}
+	if got := c.Diagnose("мость"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	plain := NewConstructor(Options{Alphabet: RussianYo})
+	if err := plain.LearnFrom(strings.NewReader("мостовая радость")); err != nil {
+		t.Fatal(err)
+	}
+	if !plain.Accepts("мость") {
+		t.Errorf("want %q to be accepted without suffix tables", "мость")
+	}
+	c.Seed(1)
+	for i := 0; i < 20; i++ {
+		if w := c.Word(7); w != "радость" {
+			t.Errorf("want %q, got %q", "радость", w)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var d Constructor
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.suffixLen() != 4 {
+		t.Errorf("want suffix length 4, got %d", d.suffixLen())
+	}
+	if got := d.Diagnose("мость"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v after loading, got %v", want, got)
+	}
+
+	r := NewConstructor(Options{SuffixLen: 2})
+	if err := r.LoadFromRWC("vocab/MAIN.RWC"); err != nil {
+		t.Fatal(err)
+	}
+	r.Seed(1)
+	if w := r.Word(8); !r.Accepts(w) {
+		t.Errorf("want an accepted word, got %q", w)
+	}
+}
+
+func TestLearning(t *testing.T) {
+	c := NewConstructor(Options{})
+	old := NewConstructor(Options{Learning: FirstNGram})
+	for _, c := range []*Constructor{c, old} {
+		if err := c.LearnFrom(strings.NewReader("колобок")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.Accepts("колобок") {
+		t.Errorf("want %q to be accepted, got %v", "колобок", c.Diagnose("колобок"))
+	}
+	want := &Rejection{4, "ng4", "олоб"}
+	if got := old.Diagnose("колобок"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	var buf bytes.Buffer
+	if _, err := old.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var d Constructor
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.Learning() != FirstNGram {
+		t.Errorf("want FirstNGram, got %v", d.Learning())
+	}
+
+	buf.Reset()
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.LoadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if d.Learning() != AllNGrams || !d.Accepts("колобок") {
+		t.Errorf("want AllNGrams, got %v", d.Learning())
+	}
+
+	// Files without the learning section do not tell how they learned.
+	buf.Reset()
+	if _, err := c.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data = data[:bytes.Index(data, []byte(extensionSignature))]
+	if err := d.LoadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if d.Learning() != LegacyLearning {
+		t.Errorf("want LegacyLearning, got %v", d.Learning())
+	}
+	if err := d.LoadFromRWC("vocab/MAIN.RWC"); err != nil {
+		t.Fatal(err)
+	}
+	if d.Learning() != LegacyLearning {
+		t.Errorf("want LegacyLearning for an .RWC file, got %v", d.Learning())
+	}
+
+	// The suffix tables learn the ends of the words whatever the learning,
+	// so they allow every word the other tables allow.
+	var count *big.Int
+	for _, suffixLen := range []int{0, 1, 3} {
+		c := NewConstructor(Options{Learning: FirstNGram, SuffixLen: suffixLen})
+		if err := c.LearnFrom(strings.NewReader("абвгд бвгде")); err != nil {
+			t.Fatal(err)
+		}
+		if !c.Accepts("абвгд") {
+			t.Errorf("suffix length %d: want %q to be accepted, got %v", suffixLen, "абвгд", c.Diagnose("абвгд"))
+		}
+		n := c.CountLength(5)
+		if count == nil {
+			count = n
+		} else if n.Cmp(count) != 0 {
+			t.Errorf("suffix length %d: want %v words, got %v", suffixLen, count, n)
+		}
+	}
+}
+
+func TestSafeConstructor(t *testing.T) {
+	s := NewSafeConstructor(&DefaultConstructor)
+	var buf bytes.Buffer
+	if _, err := DefaultConstructor.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if w := s.Word(6); utf8.RuneCountInString(w) != 6 {
+					t.Errorf("want a word of 6 letters, got %q", w)
+					return
+				}
+				s.WordMask("ко..C")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := s.LearnFrom(strings.NewReader("колобок котелок")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.LoadFrom(bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if err := s.LoadFrom(strings.NewReader("garbage")); err == nil {
+		t.Error("want an error")
+	}
+	if w := s.Word(6); w == "" {
+		t.Error("want the snapshot to survive a failed load")
+	}
+	if err := s.LearnFrom(strings.NewReader("щщщщ")); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Snapshot().Accepts("щщщщ") || DefaultConstructor.Accepts("щщщщ") {
+		t.Error("want the snapshot alone to learn")
+	}
+}
+
+func TestAppendWord(t *testing.T) {
+	c := DefaultConstructor
+	d := DefaultConstructor
+	c.Seed(1)
+	d.Seed(1)
+	buf := []byte("слово: ")
+	for i := 0; i < 20; i++ {
+		buf = c.AppendWord(buf[:len("слово: ")], 7)
+		if want := d.Word(7); string(buf) != "слово: "+want {
+			t.Errorf("want %q, got %q", "слово: "+want, buf)
+		}
+		w := c.AppendWordMask(nil, "ко..C")
+		if want := d.WordMask("ко..C"); string(w) != want {
+			t.Errorf("want %q, got %q", want, w)
+		}
+	}
+	if got := c.AppendWordMask(nil, "ъъъ"); len(got) != 0 {
+		t.Errorf("want nothing appended, got %q", got)
+	}
+
+	words := c.Words(40, 10)
+	if len(words) != 10 {
+		t.Fatalf("want 10 words, got %d", len(words))
+	}
+	for _, w := range words {
+		if utf8.RuneCountInString(w) != 40 || !c.Accepts(w) {
+			t.Errorf("want an accepted word of 40 letters, got %q", w)
+		}
+	}
+	if words := Words(3, 5); len(words) != 5 {
+		t.Errorf("want 5 words, got %v", words)
+	}
+	if words := c.Words(1, 0); words != nil {
+		t.Errorf("want no words, got %v", words)
+	}
+}
+
+func TestPickLetter(t *testing.T) {
+	var c Constructor
+	c.Seed(1)
+	src := c.source()
+	var counts [2]int
+	for i := 0; i < 10000; i++ {
+		b := c.pickLetter(src, 1<<0|1<<1, maskAny) // а and б
+		if b > 1 {
+			t.Fatalf("want а or б, got %d", b)
+		}
+		counts[b]++
+	}
+	// а is five times as frequent as б.
+	if r := float64(counts[0]) / float64(counts[1]); r < 4.5 || r > 5.6 {
+		t.Errorf("want а about five times as often as б, got %v", counts)
+	}
+	if b := c.pickLetter(src, 1<<5, maskVowel); b != 5 {
+		t.Errorf("want е, got %d", b)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	pw, entropy, err := Password(PasswordOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := CountMask("CVCVCV")
+	words := regexp.MustCompile(`[0-9]`).Split(pw, -1)
+	nf, _ := new(big.Float).SetInt(n).Float64()
+	want := float64(len(words))*math.Log2(nf) + float64(len(words)-1)*math.Log2(10)
+	if entropy < 64 || math.Abs(entropy-want) > 1e-9 {
+		t.Errorf("want entropy %v of at least 64 bits, got %v for %q", want, entropy, pw)
+	}
+	if entropy-math.Log2(nf)-math.Log2(10) >= 64 {
+		t.Errorf("want as few words as it takes, got %q", pw)
+	}
+	for _, w := range words {
+		if utf8.RuneCountInString(w) != 6 || !Accepts(w) {
+			t.Errorf("want an accepted word of 6 letters, got %q in %q", w, pw)
+		}
+	}
+
+	pw, entropy, err = Password(PasswordOptions{Mask: "CV", Entropy: 20, Separators: "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := CountMask("CV"); math.Abs(entropy-float64(strings.Count(pw, "-")+1)*log2(n)) > 1e-9 {
+		t.Errorf("wrong entropy %v of %q", entropy, pw)
+	}
+
+	if _, _, err := Password(PasswordOptions{Separators: "1-1"}); err == nil {
+		t.Error("want an error for repeated separators")
+	}
+	if _, _, err := Password(PasswordOptions{Separators: "аб"}); err == nil {
+		t.Error("want an error for letters as separators")
+	}
+	for _, entropy := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), -1} {
+		if _, _, err := Password(PasswordOptions{Entropy: entropy}); err == nil {
+			t.Errorf("want an error for entropy %v", entropy)
+		}
+	}
+	if _, _, err := Password(PasswordOptions{Mask: "ъъъ"}); err != ErrNoMatch {
+		t.Errorf("want ErrNoMatch, got %v", err)
+	}
+	if _, _, err := Password(PasswordOptions{Mask: "кот", Separators: "-"}); err == nil || err == ErrNoMatch {
+		t.Error("want an error for no entropy")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	k, err := NewCodec("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := k.Width()
+	if n < 1 {
+		t.Fatalf("want a positive width, got %d", n)
+	}
+	sums := (4 + n - 1) / n // words of a 32-bit checksum
+	for _, data := range [][]byte{nil, {0}, {0xff, 0}, {1, 2, 3}, []byte("идентификатор 42")} {
+		words := k.Encode(data)
+		if want := (len(data)+n-1)/n + sums; len(words) != want {
+			t.Errorf("want %d words, got %q", want, words)
+		}
+		for _, w := range words {
+			if utf8.RuneCountInString(w) != 6 || !Accepts(w) {
+				t.Errorf("want an accepted word of 6 letters, got %q", w)
+			}
+		}
+		got, err := k.Decode(words)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("want %v, got %v, %v", data, got, err)
+		}
+	}
+
+	// No other word at any place decodes.
+	data := []byte("идентификатор 42")
+	words := k.Encode(data)
+	for i := range words {
+		for j := 1; j <= 100; j++ {
+			corrupt := append([]string(nil), words...)
+			corrupt[i] = k.word(big.NewInt(int64(j * 37 % 256)))
+			if corrupt[i] == words[i] {
+				continue
+			}
+			if got, err := k.Decode(corrupt); !errors.Is(err, ErrMismatch) {
+				t.Fatalf("want ErrMismatch for %q, got %v, %v", corrupt, got, err)
+			}
+		}
+	}
+
+	words = k.Encode([]byte{1, 2, 3})
+	if again, _ := NewCodec("CVCVCV"); !reflect.DeepEqual(again.Encode([]byte{1, 2, 3}), words) {
+		t.Error("want the same words from the same tables")
+	}
+	words[0] = strings.ToUpper(words[0])
+	if _, err := k.Decode(words); err != nil {
+		t.Errorf("want upper case to decode, got %v", err)
+	}
+	words[0], words[1] = words[1], words[0]
+	if _, err := k.Decode(words); !errors.Is(err, ErrMismatch) {
+		t.Errorf("want ErrMismatch, got %v", err)
+	}
+
+	c := NewConstructor(Options{Alphabet: RussianYo})
+	if err := c.LearnFrom(strings.NewReader("колобок котелок молоко")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewCodec(""); err == nil {
+		t.Error("want an error for too few words")
+	}
+	d := DefaultConstructor
+	if err := d.LearnFrom(strings.NewReader("бабаба мамама")); err != nil {
+		t.Fatal(err)
+	}
+	other, err := d.NewCodec("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Decode(k.Encode([]byte{1, 2, 3})); !errors.Is(err, ErrMismatch) {
+		t.Errorf("want ErrMismatch for another vocabulary, got %v", err)
+	}
+}
+
+func TestWordFor(t *testing.T) {
+	// The word must not change between versions.
+	w := WordFor("Иванов", 7)
+	if w != "дотожем" || !Accepts(w) {
+		t.Fatalf("want %q, got %q", "дотожем", w)
+	}
+	for i := 0; i < 5; i++ {
+		if got := WordFor("Иванов", 7); got != w {
+			t.Errorf("want %q again, got %q", w, got)
+		}
+	}
+	if WordFor("Петров", 7) == w && WordFor("Сидоров", 7) == w {
+		t.Errorf("want other keys to get other words, got %q", w)
+	}
+
+	c := DefaultConstructor
+	if got := c.WordFor("Иванов", 7); got != w {
+		t.Errorf("want %q from a copy of the tables, got %q", w, got)
+	}
+	c.SetSecret([]byte("секрет"))
+	if c.WordFor("Иванов", 7) == w && c.WordFor("Петров", 7) == WordFor("Петров", 7) {
+		t.Error("want the secret to change the words")
+	}
+
+	m := c.WordMaskFor("Иванов", "ко[^ъ]{5}")
+	if !strings.HasPrefix(m, "ко") || utf8.RuneCountInString(m) != 7 || m != c.WordMaskFor("Иванов", "ко[^ъ]{5}") {
+		t.Errorf("want the same word matching the mask, got %q", m)
+	}
+	if got := c.WordMaskFor("Иванов", "ъъъ"); got != "" {
+		t.Errorf("want no word, got %q", got)
+	}
+	if c.lattices.owner != &c || c.lattices.plans["ко[^ъ]{5}"] == nil {
+		t.Error("want the constructor to keep the plan of the mask")
+	}
+	if DefaultConstructor.lattices.plans["ко[^ъ]{5}"] != nil {
+		t.Error("want the copy not to share the cache of the original")
+	}
+}
+
+func TestPseudonymize(t *testing.T) {
+	text := "Иван Петров написал: «ИВАН, привет!»\r\nWi-Fi не работает, с iPhoneом тоже; Ёжик.\nИван"
+	var sb strings.Builder
+	if err := Pseudonymize(&sb, strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+
+	split := func(s string) []string {
+		return regexp.MustCompile(`[а-яёА-ЯЁ]+|[^а-яёА-ЯЁ]+`).FindAllString(s, -1)
+	}
+	in, got := split(text), split(out)
+	if len(in) != len(got) {
+		t.Fatalf("want the tokens of %q, got %q", text, out)
+	}
+	skeleton := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case unicode.IsUpper(r) && strings.ContainsRune("АЕЁИОУЫЭЮЯ", r):
+				return 'V'
+			case unicode.IsUpper(r):
+				return 'C'
+			case strings.ContainsRune("аеёиоуыэюя", r):
+				return 'v'
+			}
+			return 'c'
+		}, s)
+	}
+	mapped := make(map[string]string)
+	for i, tok := range in {
+		switch {
+		case !regexp.MustCompile(`^[а-яёА-ЯЁ]+$`).MatchString(tok) || tok == "ом":
+			if got[i] != tok {
+				t.Errorf("want %q untouched, got %q", tok, got[i])
+			}
+		default:
+			if skeleton(got[i]) != skeleton(tok) || strings.EqualFold(got[i], tok) || !Accepts(got[i]) {
+				t.Errorf("want an accepted pseudo-word shaped like %q, got %q", tok, got[i])
+			}
+			lower := strings.ToLower(tok)
+			if m, ok := mapped[lower]; ok && m != strings.ToLower(got[i]) {
+				t.Errorf("want %q to map to %q again, got %q", tok, m, got[i])
+			}
+			mapped[lower] = strings.ToLower(got[i])
+		}
+	}
+	if !strings.Contains(out, "iPhoneом") || !strings.Contains(out, "Wi-Fi") || !strings.Contains(out, "\r\n") {
+		t.Errorf("want the other tokens untouched, got %q", out)
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	learn := func(opts Options, text string) *Constructor {
+		c := NewConstructor(opts)
+		if err := c.LearnFrom(strings.NewReader(text)); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	for _, opts := range []Options{{}, {Alphabet: RussianYo, Order: 5}} {
+		a := learn(opts, "колобок котелок")
+		b := learn(opts, "колобок молоко")
+
+		u, err := a.Union(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i, err := a.Intersect(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := a.Subtract(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range []*Constructor{u, i, s} {
+			if e.lattices == nil || e.lattices.owner != e {
+				t.Error("want a combined constructor to have a cache of its own")
+			}
+		}
+		for _, w := range []string{"колобок", "котелок", "молоко"} {
+			if !u.Accepts(w) {
+				t.Errorf("want the union to accept %q, got %v", w, u.Diagnose(w))
+			}
+		}
+		if !i.Accepts("колобок") || i.Accepts("котелок") || i.Accepts("молоко") {
+			t.Error("want the intersection to accept колобок only")
+		}
+		if s.Accepts("колобок") || !s.Accepts("котелок") {
+			t.Error("want the difference to accept котелок only")
+		}
+
+		onlyA, onlyB, err := a.Diff(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := NGram{"ng3beg", "кот"}
+		if !containsNGram(onlyA, want) || containsNGram(onlyB, want) {
+			t.Errorf("want %v in %v only", want, onlyA)
+		}
+		want = NGram{"ng3end", "око"}
+		if !containsNGram(onlyB, want) || containsNGram(onlyA, want) {
+			t.Errorf("want %v in %v only", want, onlyB)
+		}
+		if onlyA, onlyB, _ := a.Diff(a); len(onlyA) != 0 || len(onlyB) != 0 {
+			t.Errorf("want no difference, got %v and %v", onlyA, onlyB)
+		}
+	}
+
+	if _, err := NewConstructor(Options{}).Union(NewConstructor(Options{Alphabet: RussianYo})); err == nil {
+		t.Error("want an error for different alphabets")
+	}
+	if _, err := NewConstructor(Options{Alphabet: RussianYo}).Union(NewConstructor(Options{Alphabet: RussianYo, Order: 5})); err == nil {
+		t.Error("want an error for different orders")
+	}
+
+	// A classic Latin constructor combines with a model.
+	l := learn(Options{Alphabet: Latin}, "word")
+	m := NewConstructor(Options{Alphabet: Latin})
+	if err := m.LoadFromRWC("vocab/ENGLISH.RWC"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := l.Union(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Accepts("word") || !u.Accepts("luking") {
+		t.Error("want the union to accept word and luking")
+	}
+}
+
+func containsNGram(ngrams []NGram, ng NGram) bool {
+	for _, x := range ngrams {
+		if x == ng {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// SafeConstructor is a constructor that may learn or load new tables while
+// other goroutines construct words with it. A Constructor itself must not
+// be changed while it is in use.
+//
+// A SafeConstructor constructs words with a snapshot, a Constructor that
+// never changes. An update changes a copy of the snapshot and then replaces
+// the snapshot with it, so the words being constructed keep using the old
+// tables until they are done. Updates are serialized.
+type SafeConstructor struct {
+	mu   sync.Mutex   // held by updates
+	snap atomic.Value // *Constructor
+}
+
+// NewSafeConstructor returns a SafeConstructor starting with a copy of c.
+func NewSafeConstructor(c *Constructor) *SafeConstructor {
+	s := new(SafeConstructor)
+	s.snap.Store(c.clone())
+	return s
+}
+
+// Snapshot returns the current snapshot. It is safe for concurrent use by
+// everything that does not change it, like Word, Count or Mask.Generate;
+// changing it is a data race.
+func (s *SafeConstructor) Snapshot() *Constructor {
+	return s.snap.Load().(*Constructor)
+}
+
+// Update calls fn with a copy of the current snapshot and, unless fn
+// returns an error, makes the copy the new snapshot. The configuration of
+// the snapshot, like its sampling mode or seed, can only be changed with
+// Update.
+func (s *SafeConstructor) Update(fn func(c *Constructor) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.Snapshot().clone()
+	if err := fn(c); err != nil {
+		return err
+	}
+	s.snap.Store(c)
+	return nil
+}
+
+// LearnFrom is like Constructor.LearnFrom. The words learned from r only
+// appear in the new snapshot, once LearnFrom has read all of r.
+func (s *SafeConstructor) LearnFrom(r io.Reader) error {
+	return s.Update(func(c *Constructor) error { return c.LearnFrom(r) })
+}
+
+// LoadFrom is like Constructor.LoadFrom. The snapshot stays the same if
+// LoadFrom fails.
+func (s *SafeConstructor) LoadFrom(r io.Reader) error {
+	return s.Update(func(c *Constructor) error { return c.LoadFrom(r) })
+}
+
+// LoadFromRWC is like Constructor.LoadFromRWC. The snapshot stays the same
+// if LoadFromRWC fails.
+func (s *SafeConstructor) LoadFromRWC(filename string) error {
+	return s.Update(func(c *Constructor) error { return c.LoadFromRWC(filename) })
+}
+
+// Word is like Constructor.Word.
+func (s *SafeConstructor) Word(n int) string {
+	return s.Snapshot().Word(n)
+}
+
+// TryWord is like Constructor.TryWord.
+func (s *SafeConstructor) TryWord(n int) (string, error) {
+	return s.Snapshot().TryWord(n)
+}
+
+// WordMask is like Constructor.WordMask.
+func (s *SafeConstructor) WordMask(mask string) string {
+	return s.Snapshot().WordMask(mask)
+}
+
+// TryWordMask is like Constructor.TryWordMask.
+func (s *SafeConstructor) TryWordMask(mask string) (string, error) {
+	return s.Snapshot().TryWordMask(mask)
+}
+
+// AppendWord is like Constructor.AppendWord.
+func (s *SafeConstructor) AppendWord(dst []byte, n int) []byte {
+	return s.Snapshot().AppendWord(dst, n)
+}
+
+// AppendWordMask is like Constructor.AppendWordMask.
+func (s *SafeConstructor) AppendWordMask(dst []byte, mask string) []byte {
+	return s.Snapshot().AppendWordMask(dst, mask)
+}
+
+// Words is like Constructor.Words. All the words come from the same
+// snapshot.
+func (s *SafeConstructor) Words(n, count int) []string {
+	return s.Snapshot().Words(n, count)
+}
+
+// WordFor is like Constructor.WordFor.
+func (s *SafeConstructor) WordFor(key string, n int) string {
+	return s.Snapshot().WordFor(key, n)
+}
+
+// WordMaskFor is like Constructor.WordMaskFor.
+func (s *SafeConstructor) WordMaskFor(key, mask string) string {
+	return s.Snapshot().WordMaskFor(key, mask)
+}
+
+// RandomWord is like Constructor.RandomWord.
+func (s *SafeConstructor) RandomWord() string {
+	return s.Snapshot().RandomWord()
+}
+
+// clone returns a copy of c sharing nothing that c changes when it learns
+// or loads new tables.
+func (c *Constructor) clone() *Constructor {
+	d := *c
+	if c.freq != nil {
+		d.freq = c.freq.clone()
+	}
+	if c.m != nil {
+		d.m = c.m.clone()
+	}
+	if c.known != nil {
+		d.known = &bloom{k: c.known.k, bits: append([]uint64(nil), c.known.bits...)}
+	}
+	d.ownCache()
+	return &d
+}
@@ -0,0 +1,183 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// An NGram is an n-gram allowed by one of the tables of a constructor.
+type NGram struct {
+	// Table is the name of the table as in Rejection.
+	Table string
+	// NGram is the letters before the allowed letter followed by it.
+	NGram string
+}
+
+// Union returns a constructor allowing every n-gram allowed by c or d.
+// Like the constructors returned by Intersect and Subtract, it only has
+// the tables: no occurrence counts, lengths of learned words or remembered
+// words. The constructors must have the same alphabet, order and suffix
+// length.
+func (c *Constructor) Union(d *Constructor) (*Constructor, error) {
+	return c.combine(d, func(x, y uint64) uint64 { return x | y })
+}
+
+// Intersect returns a constructor allowing the n-grams allowed by both c
+// and d. See Union.
+func (c *Constructor) Intersect(d *Constructor) (*Constructor, error) {
+	return c.combine(d, func(x, y uint64) uint64 { return x & y })
+}
+
+// Subtract returns a constructor allowing the n-grams allowed by c but not
+// by d. See Union.
+func (c *Constructor) Subtract(d *Constructor) (*Constructor, error) {
+	return c.combine(d, func(x, y uint64) uint64 { return x &^ y })
+}
+
+// Diff returns the n-grams allowed by c but not by d and those allowed by d
+// but not by c, ordered by table, with shorter contexts first, and then
+// alphabetically. See Union for the constructors it compares.
+func (c *Constructor) Diff(d *Constructor) (onlyC, onlyD []NGram, err error) {
+	x, err := c.Subtract(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := d.Subtract(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return x.ngrams(), y.ngrams(), nil
+}
+
+func (c *Constructor) combine(d *Constructor, op func(x, y uint64) uint64) (*Constructor, error) {
+	if string(c.alphabet().marshal()) != string(d.alphabet().marshal()) {
+		return nil, errors.New("the constructors have different alphabets")
+	}
+	e := &Constructor{abc: c.abc}
+	e.ownCache()
+	if c.m == nil && d.m == nil {
+		op32 := func(x, y uint32) uint32 { return uint32(op(uint64(x), uint64(y))) }
+		for i := range e.ng4 {
+			e.ng4[i] = op32(c.ng4[i], d.ng4[i])
+		}
+		for i := range e.ng3 {
+			e.ng3[i] = op32(c.ng3[i], d.ng3[i])
+			e.ng3beg[i] = op32(c.ng3beg[i], d.ng3beg[i])
+			e.ng3end[i] = op32(c.ng3end[i], d.ng3end[i])
+		}
+		for i := range e.ng2 {
+			e.ng2[i] = op32(c.ng2[i], d.ng2[i])
+		}
+		e.ng1 = op32(c.ng1, d.ng1)
+		return e, nil
+	}
+
+	cm, dm := c.model(), d.model()
+	switch {
+	case cm.order() != dm.order():
+		return nil, errors.New("the constructors have different orders")
+	case len(cm.ends) != len(dm.ends):
+		return nil, errors.New("the constructors have different suffix lengths")
+	}
+	e.m = &model{
+		all:    cm.all,
+		tables: combineTables(cm.tables, dm.tables, op),
+		ends:   combineTables(cm.ends, dm.ends, op),
+	}
+	return e, nil
+}
+
+func combineTables(x, y []map[uint64]uint64, op func(x, y uint64) uint64) []map[uint64]uint64 {
+	z := make([]map[uint64]uint64, len(x))
+	for i := range z {
+		z[i] = make(map[uint64]uint64)
+		for k, s := range x[i] {
+			if s = op(s, y[i][k]); s != 0 {
+				z[i][k] = s
+			}
+		}
+		for k, s := range y[i] {
+			if _, ok := x[i][k]; ok {
+				continue
+			}
+			if s = op(0, s); s != 0 {
+				z[i][k] = s
+			}
+		}
+	}
+	return z
+}
+
+// model returns the model of c or, if c has the tables of Constructor, a
+// model holding them.
+func (c *Constructor) model() *model {
+	if c.m != nil {
+		return c.m
+	}
+	slots := make([]byte, 32)
+	for i := range slots {
+		slots[i] = 0xff
+		if i < len(c.alphabet().letters) {
+			slots[i] = byte(i)
+		}
+	}
+	m := newModel(c.alphabet(), minOrder, 0)
+	m.importTables(c, slots)
+	return m
+}
+
+// ngrams returns the n-grams allowed by the tables of c in the order
+// described for Diff.
+func (c *Constructor) ngrams() []NGram {
+	m := c.model()
+	var ngrams []NGram
+	add := func(table, k int, t map[uint64]uint64) {
+		keys := make([]uint64, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		w := make([]byte, k+1)
+		for _, key := range keys {
+			for j := k - 1; j >= 0; j-- {
+				w[j] = byte(key >> uint(6*(k-1-j)) & 63)
+			}
+			for s := t[key]; s != 0; s &= s - 1 {
+				w[k] = byte(bits.TrailingZeros64(s))
+				ngrams = append(ngrams, NGram{tableName(table), c.makeString(w)})
+			}
+		}
+	}
+	for table, t := range m.tables {
+		var k int
+		switch {
+		case table == tabNg1:
+			k = 0
+		case table == tabNg2:
+			k = 1
+		case table < tabNg4:
+			k = 2
+		default:
+			k = table - tabNg4 + 3
+		}
+		add(table, k, t)
+	}
+	for i, t := range m.ends {
+		add(tabEnd+i, 3, t)
+	}
+	return ngrams
+}
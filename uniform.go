@@ -0,0 +1,146 @@
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License as published by the Free
+// Software Foundation, either version 3 of the License, or (at your option)
+// any later version.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the GNU General
+// Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package rwc
+
+import (
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+// Sampling selects how a Constructor picks one of the words it is able to
+// construct.
+type Sampling int
+
+const (
+	// Frequency picks letters in proportion to their frequency in Russian
+	// or, if the constructor holds occurrence counts, in the corpus it
+	// learned from. This is the default.
+	Frequency Sampling = iota
+
+	// Uniform makes every word of the requested length or mask equally
+	// likely. It counts the paths through the n-gram tables first, which
+	// takes a few milliseconds for each new length or mask.
+	Uniform
+)
+
+// SetSampling sets the sampling mode used by Word, WordMask and their Try
+// variants. It must not be called concurrently with them.
+func (c *Constructor) SetSampling(s Sampling) {
+	c.sampling = s
+	c.ownCache()
+}
+
+// latticeCache keeps the lattices built for uniform sampling and the plans
+// of WordMaskFor until the tables change.
+type latticeCache struct {
+	owner *Constructor
+	mu    sync.Mutex
+	rev   uint64
+	m     map[string][]*lattice
+	plans map[string]*plan
+}
+
+// ownCache gives c a cache of its own, unless it has one already; a copy of
+// a constructor has the cache of the original, which it must not use. Only
+// the methods changing c call it.
+func (c *Constructor) ownCache() {
+	if c.lattices == nil || c.lattices.owner != c {
+		c.lattices = &latticeCache{owner: c, m: make(map[string][]*lattice)}
+	}
+}
+
+// reset empties lc if the tables have changed. It is called with lc.mu held.
+func (lc *latticeCache) reset(rev uint64) {
+	if lc.rev != rev {
+		lc.rev = rev
+		lc.m = make(map[string][]*lattice)
+		lc.plans = nil
+	}
+}
+
+const maxCachedLattices = 64
+
+func (c *Constructor) cachedLattices(p *pattern) []*lattice {
+	lc := c.lattices
+	if lc == nil || lc.owner != c {
+		return c.buildLattices(p, p.lengths())
+	}
+
+	lc.mu.Lock()
+	lc.reset(c.rev)
+	ll := lc.m[p.src]
+	lc.mu.Unlock()
+	if ll != nil {
+		return ll
+	}
+
+	ll = c.buildLattices(p, p.lengths())
+	lc.mu.Lock()
+	if lc.rev == c.rev {
+		if len(lc.m) >= maxCachedLattices {
+			lc.m = make(map[string][]*lattice)
+		}
+		lc.m[p.src] = ll
+	}
+	lc.mu.Unlock()
+	return ll
+}
+
+// uniform returns a word matching p, chosen with equal probability among
+// all the words c is able to construct, or nil if there is no such word.
+func (c *Constructor) uniform(src *source, p *pattern) []byte {
+	ll := c.cachedLattices(p)
+	total := new(big.Int)
+	for _, l := range ll {
+		total.Add(total, l.total())
+	}
+	if total.Sign() == 0 {
+		return nil
+	}
+	r := new(big.Int).Rand(src.rand, total)
+	for _, l := range ll {
+		cnt := l.total()
+		if r.Cmp(cnt) < 0 {
+			return l.unrank(r)
+		}
+		r.Sub(r, cnt)
+	}
+	return nil
+}
+
+// unrank returns the word with the specified index in the lexicographically
+// ordered list of the words accepted by l.
+func (l *lattice) unrank(index *big.Int) []byte {
+	w := make([]byte, l.n)
+	r := new(big.Int).Set(index)
+	var st state
+	for i := 0; i < l.n; i++ {
+		for s := l.letters(w, i, st); s != 0; s &= s - 1 {
+			b := bits.TrailingZeros64(s)
+			next := l.step(i, st, byte(b))
+			cnt := l.count(i+1, next)
+			if cnt == nil {
+				continue
+			}
+			if r.Cmp(cnt) < 0 {
+				w[i] = byte(b)
+				st = next
+				break
+			}
+			r.Sub(r, cnt)
+		}
+	}
+	return w
+}